@@ -1,41 +1,125 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/ktt-ol/spaceDevices/internal/conf"
 	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/internal/history"
 	"github.com/ktt-ol/spaceDevices/internal/mqtt"
+	"github.com/ktt-ol/spaceDevices/internal/oui"
+	"github.com/ktt-ol/spaceDevices/internal/reload"
+	"github.com/ktt-ol/spaceDevices/internal/sources"
 	"github.com/ktt-ol/spaceDevices/internal/webService"
 	"github.com/sirupsen/logrus"
 )
 
 const CONFIG_FILE = "config.toml"
 
+// max time to wait for the running http requests on shutdown
+const WEB_SHUTDOWN_TIMEOUT = 10 * time.Second
+
 func main() {
 	config := conf.LoadConfig(CONFIG_FILE)
 
 	setupLogging(config.Misc)
 
+	if len(os.Args) > 1 {
+		runCommand(config, os.Args[1], os.Args[2:])
+		return
+	}
+
 	logrus.WithFields(logrus.Fields{
 		"session": config.Mqtt.SessionTopic,
 		"devices": config.Mqtt.DevicesTopic,
 		"mqttUser": config.Mqtt.Username,
 		"master": config.MacDb.MasterFile,
 		"user": config.MacDb.UserFile,
+		"history": config.History.File,
 	}).Info("SpaceDevices starting...")
 
 	//mqtt.EnableMqttDebugLogging()
 
-	userDb := db.NewUserDb(config.MacDb)
+	unlockUserDb, err := db.LockUserDb(config.MacDb)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not lock the user db, is the service already running?")
+	}
+	userDb := db.OpenUserDb(config.MacDb)
 	masterDb := db.NewMasterDb(config.MacDb)
+	var userDbExpiry *db.UserDbExpiry
+	if config.MacDb.ExpireAfterInMonths > 0 {
+		userDbExpiry = db.NewUserDbExpiry(config.MacDb, userDb)
+	}
+
+	var presenceHistory *history.PresenceHistory
+	if config.History.File != "" {
+		presenceHistory = history.NewPresenceHistory(config.History)
+	}
 
 	mqttHandler := mqtt.NewMqttHandler(config.Mqtt, false)
-	data := mqtt.NewDeviceData(config.Locations, mqttHandler, masterDb, userDb)
-	data.ListenAndUpdatePeopleData()
+	data := mqtt.NewDeviceData(config.Locations, loadTimezone(config.Misc), config.Debounce, mqttHandler, masterDb, userDb, presenceHistory)
+	sessionSources := []sources.SessionSource{mqtt.NewWifiSessionSource(mqttHandler)}
+	if config.Sources.Arp.Enabled {
+		sessionSources = append(sessionSources, sources.NewArpSource(config.Sources.Arp))
+	}
+	if config.Sources.Dhcp.LeaseFile != "" {
+		sessionSources = append(sessionSources, sources.NewDhcpSource(config.Sources.Dhcp))
+	}
+	data.ListenAndUpdatePeopleData(sessionSources)
+
+	var ouiDb *oui.Db
+	if len(config.MacDb.VendorFiles) > 0 {
+		var err error
+		if ouiDb, err = oui.Load(config.MacDb.VendorFiles...); err != nil {
+			logrus.WithError(err).Fatal("Could not read the vendorFiles.")
+		}
+	}
+	server := webService.StartWebService(config.Server, data, userDb, ouiDb, presenceHistory)
 
-	webService.StartWebService(config.Server, data, userDb)
+	locations := config.Locations
+	reloader := reload.NewReloader(time.Duration(config.Misc.ReloadIntervalInSeconds)*time.Second, func() {
+		locations = reloadData(masterDb, data, locations)
+	}, config.MacDb.MasterFile, CONFIG_FILE)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	sig := <-signals
+	for sig == syscall.SIGHUP {
+		reloader.Trigger()
+		sig = <-signals
+	}
+	logrus.WithField("signal", sig).Info("SpaceDevices stopping...")
+	reloader.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), WEB_SHUTDOWN_TIMEOUT)
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Warn("Web server shutdown failed.")
+	}
+	cancel()
+
+	data.Stop()
+	for _, source := range sessionSources {
+		source.Stop()
+	}
+	mqttHandler.Shutdown()
+	if userDbExpiry != nil {
+		userDbExpiry.Stop()
+	}
+	userDb.Close()
+	unlockUserDb()
+	if presenceHistory != nil {
+		presenceHistory.Close()
+	}
+
+	logrus.Info("SpaceDevices stopped.")
 }
 
 type StdErrLogHook struct {
@@ -75,3 +159,106 @@ func setupLogging(config conf.MiscConf) {
 		logrus.AddHook(&StdErrLogHook{})
 	}
 }
+
+func loadTimezone(config conf.MiscConf) *time.Location {
+	if config.Timezone == "" {
+		return time.Local
+	}
+
+	timezone, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		logrus.WithError(err).WithField("timezone", config.Timezone).Fatal("Invalid timezone.")
+	}
+	return timezone
+}
+
+// reloadData reads the master db and the locations of the config again and returns the locations in use. Invalid files
+// are logged and the current values are kept.
+func reloadData(masterDb db.MasterDb, data *mqtt.DeviceData, locations []conf.Location) []conf.Location {
+	if err := masterDb.Reload(); err != nil {
+		logrus.WithError(err).Error("Invalid masterFile, keeping the current entries.")
+	}
+
+	config, err := conf.ReadConfig(CONFIG_FILE)
+	if err != nil {
+		logrus.WithError(err).Error("Invalid config, keeping the current locations.")
+	} else {
+		locations = config.Locations
+	}
+	data.Reload(locations)
+	return locations
+}
+
+// runCommand executes a maintenance command instead of the service
+func runCommand(config conf.TomlConfig, command string, args []string) {
+	switch command {
+	case "expire":
+		flags := flag.NewFlagSet("expire", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "only list the entries that would be removed")
+		months := flags.Int("months", config.MacDb.ExpireAfterInMonths, "remove the entries not seen for this amount of months")
+		flags.Parse(args)
+		if *months < 1 {
+			logrus.Fatal("Missing months, set expireAfterInMonths or use -months.")
+		}
+
+		if !*dryRun {
+			// the running service would write the removed entries back
+			unlock, err := db.LockUserDb(config.MacDb)
+			if err != nil {
+				logrus.WithError(err).Fatal("The user db is in use, stop the service first or use -dry-run.")
+			}
+			defer unlock()
+		}
+		userDb := db.OpenUserDb(config.MacDb)
+		expired := userDb.Expire(db.SeenBefore(time.Now(), *months), *dryRun)
+		for _, entry := range expired {
+			fmt.Printf("%s\t%s\t%s\t%s\n", entry.Mac, time.Unix(entry.Entry.LastSeen/1000, 0).Format("2006-01-02"),
+				entry.Person.Name, entry.Entry.DeviceName)
+		}
+		if *dryRun {
+			fmt.Printf("%d entries would be removed.\n", len(expired))
+		} else {
+			fmt.Printf("%d entries removed.\n", len(expired))
+		}
+		userDb.Close()
+	case "import-userdb":
+		count, err := db.ImportUserDb(config.MacDb)
+		if err != nil {
+			logrus.WithError(err).Fatal("Import failed.")
+		}
+		fmt.Printf("%d entries imported from %s into %s.\n", count, config.MacDb.UserFile, config.MacDb.UserBoltFile)
+	case "export-userdb":
+		flags := flag.NewFlagSet("export-userdb", flag.ExitOnError)
+		overwrite := flags.Bool("overwrite", false, "replace an existing userFile")
+		flags.Parse(args)
+
+		count, err := db.ExportUserDb(config.MacDb, *overwrite)
+		if err != nil {
+			logrus.WithError(err).Fatal("Export failed.")
+		}
+		fmt.Printf("%d entries exported from %s into %s.\n", count, config.MacDb.UserBoltFile, config.MacDb.UserFile)
+	case "convert-oui":
+		flags := flag.NewFlagSet("convert-oui", flag.ExitOnError)
+		out := flags.String("out", "macVendorDb.csv", "the converted file")
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			logrus.Fatal("Missing registry files, e.g. oui.csv mam.csv oui36.csv")
+		}
+
+		ouiDb, err := oui.Load(flags.Args()...)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not read the registry files.")
+		}
+		var converted bytes.Buffer
+		if err = ouiDb.WriteConverted(&converted); err != nil {
+			logrus.WithError(err).Fatal("Conversion failed.")
+		}
+		if err = ioutil.WriteFile(*out, converted.Bytes(), 0644); err != nil {
+			logrus.WithError(err).Fatal("Could not write the converted file.")
+		}
+		fmt.Printf("%d entries written to %s.\n", ouiDb.Count(), *out)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command '%s', known commands: expire, import-userdb, export-userdb, convert-oui\n", command)
+		os.Exit(2)
+	}
+}
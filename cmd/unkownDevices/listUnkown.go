@@ -1,13 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"github.com/ktt-ol/spaceDevices/internal/conf"
 	"github.com/ktt-ol/spaceDevices/internal/db"
 	"github.com/ktt-ol/spaceDevices/internal/mqtt"
-	"os"
-	"strings"
+	"github.com/ktt-ol/spaceDevices/internal/oui"
 )
 
 const CONFIG_FILE = "config.toml"
@@ -24,19 +22,24 @@ const (
 func main() {
 	config := conf.LoadConfig(CONFIG_FILE)
 
-	userDb := db.NewUserDb(config.MacDb)
+	userDb := db.OpenUserDb(config.MacDb)
 	masterDb := db.NewMasterDb(config.MacDb)
 
 	mqttHandler := mqtt.NewMqttHandler(config.Mqtt, true)
-	data := mqtt.NewDeviceData(config.Locations, mqttHandler, masterDb, userDb)
+	data := mqtt.NewDeviceData(config.Locations, nil, config.Debounce, mqttHandler, masterDb, userDb, nil)
 	unknownSession := data.GetOneEntry()
 
-	macDb := loadMacDb()
+	vendorFiles := config.MacDb.VendorFiles
+	if len(vendorFiles) == 0 {
+		vendorFiles = []string{"macVendorDb.csv"}
+	}
+	ouiDb, err := oui.Load(vendorFiles...)
+	check(err)
 	for _, s := range unknownSession {
-		// 5c:51:4f
-		firstHexBytes := strings.ToUpper(strings.ReplaceAll(s.Mac[0:8], ":", ""))
-		name, ok := macDb[firstHexBytes]
-		if !ok {
+		name, ok := ouiDb.Lookup(s.Mac)
+		if s.Mac.IsLocallyAdministered() {
+			name = "Randomized"
+		} else if !ok {
 			name = "Unknown"
 		}
 		fmt.Printf("%s %s\n", fmt.Sprintf(InfoColor, s.Mac), name)
@@ -47,25 +50,6 @@ func main() {
 	}
 }
 
-func loadMacDb() map[string]string {
-	file, err := os.Open("macVendorDb.csv")
-	check(err)
-	defer file.Close()
-
-	macDb := make(map[string]string)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		assignment := line[0:6]
-		name := line[7:]
-		macDb[assignment] = name
-	}
-
-	check(scanner.Err())
-
-	return macDb
-}
-
 func check(e error) {
 	if e != nil {
 		panic(e)
@@ -1,18 +1,55 @@
 package conf
 
 import (
+	"fmt"
+
 	"github.com/BurntSushi/toml"
 	log "github.com/sirupsen/logrus"
 )
 
 func LoadConfig(configFile string) TomlConfig {
 	log.WithField("configFile", configFile).Info("Loading config.")
-	config := &TomlConfig{}
-	if _, err := toml.DecodeFile(configFile, config); err != nil {
+	config, err := ReadConfig(configFile)
+	if err != nil {
 		log.WithError(err).Fatal("Could not read config file.")
 	}
 
-	return *config
+	return config
+}
+
+// ReadConfig reads and validates the config file, e.g. for a reload
+func ReadConfig(configFile string) (TomlConfig, error) {
+	config := TomlConfig{}
+	if _, err := toml.DecodeFile(configFile, &config); err != nil {
+		return config, err
+	}
+	if err := ValidateLocations(config.Locations); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// ValidateLocations checks for missing or duplicated names and access points in more than one location
+func ValidateLocations(locations []Location) error {
+	names := make(map[string]bool)
+	ids := make(map[int]string)
+	for _, location := range locations {
+		if location.Name == "" {
+			return fmt.Errorf("location without a name")
+		}
+		if names[location.Name] {
+			return fmt.Errorf("duplicated location '%s'", location.Name)
+		}
+		names[location.Name] = true
+		for _, id := range location.Ids {
+			if other, ok := ids[id]; ok {
+				return fmt.Errorf("access point %d is in location '%s' and '%s'", id, other, location.Name)
+			}
+			ids[id] = location.Name
+		}
+	}
+	return nil
 }
 
 type TomlConfig struct {
@@ -20,12 +57,20 @@ type TomlConfig struct {
 	Server    ServerConf
 	MacDb     MacDbConf
 	Mqtt      MqttConf
+	History   HistoryConf
+	Debounce  DebounceConf
+	Sources   SourcesConf
 	Locations []Location `toml:"location"`
 }
 
 type MiscConf struct {
 	DebugLogging bool
 	Logfile      string
+	// the IANA name of the time zone of the space, e.g. "Europe/Berlin". The local time zone is used, if empty.
+	Timezone string
+	// the master file and the locations of the config file are checked for changes in this interval. A value < 1
+	// disables it, a SIGHUP reloads them anyway.
+	ReloadIntervalInSeconds int
 }
 
 type ServerConf struct {
@@ -44,6 +89,18 @@ type Location struct {
 type MacDbConf struct {
 	MasterFile string
 	UserFile   string
+	// "json" (default) stores the user db in the UserFile, "bolt" in the UserBoltFile
+	UserBackend  string
+	UserBoltFile string
+	// user db entries not seen for this amount of months are removed. A value < 1 disables it.
+	ExpireAfterInMonths int
+	// optional, the removed entries are appended to this file
+	ArchiveFile string
+	// the amount of hourly backups of the user file (only the json backend). A value < 1 disables them.
+	UserFileBackups int
+	// optional, the IEEE registries (oui.csv, mam.csv, oui36.csv) or the converted macVendorDb.csv, to suggest a device
+	// type in the web form
+	VendorFiles []string
 }
 
 type MqttConf struct {
@@ -51,8 +108,78 @@ type MqttConf struct {
 	Username string
 	Password string
 	// if empty, the system certificates are used
-	CertFile                 string
-	SessionTopic             string
-	DevicesTopic             string
+	CertFile     string
+	SessionTopic string
+	DevicesTopic string
+	// if empty, no arrive/leave events are sent
+	EventsTopic string
+	// if empty, no service status is sent
+	StatusTopic string
+	// if empty, no summary of the devices per device type is sent
+	DeviceTypesTopic string
+	// the devices are republished after this amount of minutes, even if nothing changed. A value < 1 disables it.
+	HeartbeatInMinutes int
+	// without any sessions after this amount of minutes, the watch dog resubscribes, after twice the time it
+	// reconnects and after three times the time the devices are marked as stale. A value < 1 disables the watch dog.
 	WatchDogTimeoutInMinutes int
+	// the program exits after this amount of minutes without any sessions, at the earliest one minute after the
+	// devices are marked as stale. A value < 1 disables it.
+	WatchDogExitInMinutes int
+	// if empty, the critical and important infrastructure of the master db isn't watched
+	InfrastructureTopic string
+	// a missing critical infrastructure device is alerted to the events topic after this amount of minutes. A value < 1
+	// disables the alerts.
+	InfrastructureAlertInMinutes int
+	// if empty, the space state isn't used. The state is closed, if the payload is one of the SpaceClosedStates
+	// (default "closed"), otherwise it's open.
+	SpaceStateTopic   string
+	SpaceClosedStates []string
+	// if empty (or without SpaceStateTopic), no warnings for the devices with the powered-while-closed-warning are sent
+	PoweredWarningTopic string
+	// the warning is repeated after this amount of minutes while the devices are online. A value < 1 warns only once.
+	PoweredWarningRepeatInMinutes int
+}
+
+type HistoryConf struct {
+	// if empty, no presence history is recorded
+	File string
+	// events older than this amount of days are removed, a value < 1 keeps them forever
+	RetentionInDays int
+	// only this amount of the newest events are kept, a value < 1 means no limit. If both limits are < 1, 500000 events
+	// are kept. Up to 10% more events are held until they are removed.
+	MaxEvents int
+}
+
+type DebounceConf struct {
+	// a new device counts only after it was seen for this amount of minutes, a value < 1 counts it immediately
+	ArrivalDelayInMinutes int
+	// a disappeared device still counts for this amount of minutes, a value < 1 removes it immediately
+	GracePeriodInMinutes int
+}
+
+// SourcesConf contains the additional session sources, the wifi sessions from mqtt are always used.
+type SourcesConf struct {
+	Arp  ArpSourceConf
+	Dhcp DhcpSourceConf
+}
+
+type ArpSourceConf struct {
+	Enabled bool
+	// "proc" reads /proc/net/arp (ipv4 only), "ip" uses the output of `ip neigh show`
+	Mode string
+	// if not empty, only the neighbors of this interface are used
+	Interface string
+	// the location for all devices of this source
+	Location          string
+	IntervalInSeconds int
+}
+
+type DhcpSourceConf struct {
+	// if empty, this source is disabled
+	LeaseFile string
+	// "isc" or "dnsmasq"
+	Format string
+	// the location for all devices of this source
+	Location          string
+	IntervalInSeconds int
 }
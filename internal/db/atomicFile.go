@@ -0,0 +1,75 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// a new backup is created with the next save after this time
+const backupInterval = time.Hour
+
+// writeFileAtomic writes the data to a temp file and renames it to the given file name. Thus the file contains either
+// the old or the new data, even after a crash.
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(fileName)
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	// no-op after the rename
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpFile.Name(), perm); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), fileName); err != nil {
+		return err
+	}
+
+	// persist the rename, not supported on every platform
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+	return nil
+}
+
+// backupFileName returns the name of the backup, 1 is the newest
+func backupFileName(fileName string, index int) string {
+	return fmt.Sprintf("%s.%d", fileName, index)
+}
+
+// rotateBackups shifts the existing backups and copies the current file to the first backup. Only count backups are
+// kept.
+func rotateBackups(fileName string, count int) error {
+	content, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := count - 1; i >= 1; i-- {
+		err = os.Rename(backupFileName(fileName, i), backupFileName(fileName, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return writeFileAtomic(backupFileName(fileName, 1), content, 0644)
+}
@@ -0,0 +1,332 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/internal/metrics"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	personsBucket = []byte("persons")
+	devicesBucket = []byte("devices")
+)
+
+// returned to roll back a transaction without an error
+var errRollback = errors.New("rollback")
+
+// BoltUserDb stores the user db in a bbolt file, every change writes only the changed entries. The values are the same
+// JSON objects as in the JSON file.
+type BoltUserDb struct {
+	db     *bolt.DB
+	config conf.MacDbConf
+}
+
+func NewBoltUserDb(config conf.MacDbConf) UserDb {
+	boltDb, err := openBolt(config.UserBoltFile)
+	if err != nil {
+		log.Fatal("UserBoltFile error: ", err)
+	}
+	return &BoltUserDb{db: boltDb, config: config}
+}
+
+func openBolt(fileName string) (*bolt.DB, error) {
+	boltDb, err := bolt.Open(fileName, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = boltDb.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(personsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(devicesBucket)
+		return err
+	})
+	if err != nil {
+		boltDb.Close()
+		return nil, err
+	}
+	return boltDb, nil
+}
+
+func (db *BoltUserDb) Get(mac structs.Mac) (UserDbEntry, bool) {
+	var entry UserDbEntry
+	var ok bool
+	db.view(func(tx *bolt.Tx) error {
+		ok = getJson(tx.Bucket(devicesBucket), mac.String(), &entry)
+		return nil
+	})
+	return entry, ok
+}
+
+func (db *BoltUserDb) Set(mac structs.Mac, info UserDbEntry) {
+	db.update("set", func(tx *bolt.Tx) error {
+		devices := tx.Bucket(devicesBucket)
+		var before UserDbEntry
+		ok := getJson(devices, mac.String(), &before)
+		if err := putJson(devices, mac.String(), withLastSeen(info)); err != nil {
+			return err
+		}
+		if ok && before.PersonId != info.PersonId {
+			return deleteOrphanedPerson(tx, before.PersonId)
+		}
+		return nil
+	})
+}
+
+func (db *BoltUserDb) Delete(mac structs.Mac) {
+	db.update("delete", func(tx *bolt.Tx) error {
+		devices := tx.Bucket(devicesBucket)
+		var entry UserDbEntry
+		ok := getJson(devices, mac.String(), &entry)
+		if err := devices.Delete([]byte(mac)); err != nil {
+			return err
+		}
+		if ok {
+			return deleteOrphanedPerson(tx, entry.PersonId)
+		}
+		return nil
+	})
+}
+
+func (db *BoltUserDb) FindByPerson(personId string) map[structs.Mac]UserDbEntry {
+	result := make(map[structs.Mac]UserDbEntry)
+	db.view(func(tx *bolt.Tx) error {
+		return forEachDevice(tx, func(mac structs.Mac, entry UserDbEntry) {
+			if entry.PersonId == personId {
+				result[mac] = entry
+			}
+		})
+	})
+	return result
+}
+
+func (db *BoltUserDb) FindByReclaimToken(token string) (structs.Mac, UserDbEntry, bool) {
+	var foundMac structs.Mac
+	var found UserDbEntry
+	if token == "" {
+		return foundMac, found, false
+	}
+	db.view(func(tx *bolt.Tx) error {
+		return forEachDevice(tx, func(mac structs.Mac, entry UserDbEntry) {
+			if entry.ReclaimToken == token {
+				foundMac, found = mac, entry
+			}
+		})
+	})
+	return foundMac, found, foundMac != ""
+}
+
+func (db *BoltUserDb) GetPerson(id string) (Person, bool) {
+	var person Person
+	var ok bool
+	db.view(func(tx *bolt.Tx) error {
+		ok = getJson(tx.Bucket(personsBucket), id, &person)
+		return nil
+	})
+	return person, ok
+}
+
+func (db *BoltUserDb) NewPerson(name string, defaultVisibility Visibility) Person {
+	now := time.Now().Unix() * 1000
+	person := Person{Id: newPersonId(), Name: name, DefaultVisibility: defaultVisibility, Created: now, Updated: now}
+	db.update("set-person", func(tx *bolt.Tx) error {
+		return putJson(tx.Bucket(personsBucket), person.Id, person)
+	})
+	return person
+}
+
+func (db *BoltUserDb) SetPerson(person Person) {
+	db.update("set-person", func(tx *bolt.Tx) error {
+		persons := tx.Bucket(personsBucket)
+		if persons.Get([]byte(person.Id)) == nil {
+			log.WithField("id", person.Id).Error("Unknown person.")
+			return errRollback
+		}
+		person.Updated = time.Now().Unix() * 1000
+		return putJson(persons, person.Id, person)
+	})
+}
+
+func (db *BoltUserDb) UpdateLastSeen(macs []structs.Mac, ts int64) {
+	// most of the time nothing changed, avoid the write transaction
+	outdated := make(map[structs.Mac]UserDbEntry)
+	db.view(func(tx *bolt.Tx) error {
+		devices := tx.Bucket(devicesBucket)
+		for _, mac := range macs {
+			var entry UserDbEntry
+			if getJson(devices, mac.String(), &entry) && ts-entry.LastSeen >= int64(lastSeenResolution/time.Millisecond) {
+				outdated[mac] = entry
+			}
+		}
+		return nil
+	})
+	if len(outdated) == 0 {
+		return
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		devices := tx.Bucket(devicesBucket)
+		for mac := range outdated {
+			// read again, it could be changed in the meantime
+			var entry UserDbEntry
+			if !getJson(devices, mac.String(), &entry) {
+				continue
+			}
+			entry.LastSeen = ts
+			if err := putJson(devices, mac.String(), entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("Can't update the last seen timestamps.")
+	}
+}
+
+func (db *BoltUserDb) Expire(seenBefore int64, dryRun bool) []ExpiredEntry {
+	now := time.Now().Unix() * 1000
+	var expired []ExpiredEntry
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		persons := tx.Bucket(personsBucket)
+		err := forEachDevice(tx, func(mac structs.Mac, entry UserDbEntry) {
+			if entry.LastSeen < seenBefore {
+				var person Person
+				getJson(persons, entry.PersonId, &person)
+				expired = append(expired, ExpiredEntry{Mac: mac, Entry: entry, Person: person, Expired: now})
+			}
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(expired, func(i, j int) bool {
+			return expired[i].Mac < expired[j].Mac
+		})
+		if dryRun || len(expired) == 0 {
+			return errRollback
+		}
+
+		devices := tx.Bucket(devicesBucket)
+		for _, entry := range expired {
+			if err := devices.Delete([]byte(entry.Mac)); err != nil {
+				return err
+			}
+		}
+		for _, entry := range expired {
+			if err := deleteOrphanedPerson(tx, entry.Entry.PersonId); err != nil {
+				return err
+			}
+		}
+		// archive last, nothing is removed if it fails
+		if db.config.ArchiveFile != "" {
+			if err := appendToArchive(db.config.ArchiveFile, expired); err != nil {
+				log.WithError(err).Error("Can't archive the expired entries, nothing removed.")
+				expired = nil
+				return errRollback
+			}
+		}
+		return nil
+	})
+	if err == errRollback {
+		return expired
+	}
+	if err != nil {
+		log.WithError(err).Error("Can't expire the userDb entries.")
+		return nil
+	}
+	metrics.UserDbWrites.WithLabelValues("expire").Add(float64(len(expired)))
+
+	return expired
+}
+
+func (db *BoltUserDb) Count() int {
+	count := 0
+	db.view(func(tx *bolt.Tx) error {
+		count = tx.Bucket(devicesBucket).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+// Close waits for a running write and closes the file
+func (db *BoltUserDb) Close() {
+	if err := db.db.Close(); err != nil {
+		log.WithError(err).Error("Can't close the userDb.")
+	}
+}
+
+func (db *BoltUserDb) view(fn func(tx *bolt.Tx) error) {
+	if err := db.db.View(fn); err != nil {
+		log.WithError(err).Error("Can't read the userDb.")
+	}
+}
+
+// update runs fn in a write transaction and counts the write with the given metrics label
+func (db *BoltUserDb) update(operation string, fn func(tx *bolt.Tx) error) {
+	err := db.db.Update(fn)
+	if err == errRollback {
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("operation", operation).Error("Can't write the userDb.")
+		return
+	}
+	metrics.UserDbWrites.WithLabelValues(operation).Inc()
+}
+
+// deleteOrphanedPerson removes the person, if it has no devices
+func deleteOrphanedPerson(tx *bolt.Tx, personId string) error {
+	if personId == "" {
+		return nil
+	}
+	hasDevices := false
+	err := forEachDevice(tx, func(mac structs.Mac, entry UserDbEntry) {
+		if entry.PersonId == personId {
+			hasDevices = true
+		}
+	})
+	if err != nil || hasDevices {
+		return err
+	}
+	return tx.Bucket(personsBucket).Delete([]byte(personId))
+}
+
+func forEachDevice(tx *bolt.Tx, fn func(mac structs.Mac, entry UserDbEntry)) error {
+	return tx.Bucket(devicesBucket).ForEach(func(key, value []byte) error {
+		var entry UserDbEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		// only canonical macs are stored
+		fn(structs.Mac(key), entry)
+		return nil
+	})
+}
+
+// getJson unmarshals the value of the key, returns false if the key doesn't exist or the value is invalid
+func getJson(bucket *bolt.Bucket, key string, value interface{}) bool {
+	raw := bucket.Get([]byte(key))
+	if raw == nil {
+		return false
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		log.WithError(err).WithField("key", key).Error("Invalid userDb value.")
+		return false
+	}
+	return true
+}
+
+func putJson(bucket *bolt.Bucket, key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), raw)
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"strings"
+)
+
+// UserDeviceTypes are the device types a user can choose for a device
+var UserDeviceTypes = []string{"phone", "laptop", "tablet", "desktop", "watch", "printer", "iot", "other"}
+
+// IsValidUserDeviceType returns true for one of the UserDeviceTypes and the empty type
+func IsValidUserDeviceType(deviceType string) bool {
+	if deviceType == "" {
+		return true
+	}
+	for _, valid := range UserDeviceTypes {
+		if deviceType == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// the first matching (lower case) part of the vendor name suggests the device type
+var vendorDeviceTypes = []struct {
+	vendorPart string
+	deviceType string
+}{
+	{"apple", "phone"},
+	{"samsung", "phone"},
+	{"huawei", "phone"},
+	{"xiaomi", "phone"},
+	{"oneplus", "phone"},
+	{"motorola", "phone"},
+	{"fairphone", "phone"},
+	{"htc", "phone"},
+	{"oppo", "phone"},
+	{"vivo mobile", "phone"},
+	{"google", "phone"},
+	{"intel", "laptop"},
+	{"liteon", "laptop"},
+	{"azurewave", "laptop"},
+	{"hon hai", "laptop"},
+	{"lenovo", "laptop"},
+	{"dell", "laptop"},
+	{"hewlett packard", "laptop"},
+	{"asustek", "laptop"},
+	{"brother", "printer"},
+	{"canon", "printer"},
+	{"seiko epson", "printer"},
+	{"kyocera", "printer"},
+	{"xerox", "printer"},
+	{"espressif", "iot"},
+	{"raspberry pi", "iot"},
+	{"tuya", "iot"},
+}
+
+// SuggestDeviceType returns the most likely of the UserDeviceTypes for the vendor or an empty string
+func SuggestDeviceType(vendor string) string {
+	vendor = strings.ToLower(vendor)
+	for _, entry := range vendorDeviceTypes {
+		if strings.Contains(vendor, entry.vendorPart) {
+			return entry.deviceType
+		}
+	}
+	return ""
+}
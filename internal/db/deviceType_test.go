@@ -0,0 +1,22 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SuggestDeviceType(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("phone", SuggestDeviceType("Apple, Inc."))
+	a.Equal("laptop", SuggestDeviceType("Intel Corporate"))
+	a.Equal("iot", SuggestDeviceType("Espressif Inc."))
+	a.Equal("", SuggestDeviceType("Unknown Vendor"))
+	a.Equal("", SuggestDeviceType(""))
+
+	for _, entry := range vendorDeviceTypes {
+		a.True(IsValidUserDeviceType(entry.deviceType), entry.deviceType)
+	}
+	a.True(IsValidUserDeviceType(""))
+	a.False(IsValidUserDeviceType("server"))
+}
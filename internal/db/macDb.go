@@ -2,47 +2,109 @@ package db
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"sync"
 
 	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
 	log "github.com/sirupsen/logrus"
 )
 
+var masterLogger = log.WithField("where", "masterDb")
+
 type MasterDb interface {
-	Get(mac string) (MasterDbEntry, bool)
+	Get(mac structs.Mac) (MasterDbEntry, bool)
+	Count() int
+	// FindByVisibility returns all entries with one of the given visibilities
+	FindByVisibility(visibilities ...Visibility) map[structs.Mac]MasterDbEntry
+	// Reload reads the master file again. The current entries are kept, if the file is invalid.
+	Reload() error
 }
 
 type MasterDbEntry struct {
 	UserDbEntry
-	DeviceType                string `json:"device-type"`
-	PoweredWhileClosedWarning bool   `json:"powered-while-closed-warning"`
+	PoweredWhileClosedWarning bool `json:"powered-while-closed-warning"`
 }
 
 type fileMasterDb struct {
-	masterMap map[string]MasterDbEntry
+	fileName string
+	// guards the masterMap, it's replaced on reload
+	lock      sync.RWMutex
+	masterMap map[structs.Mac]MasterDbEntry
 }
 
 func NewMasterDb(config conf.MacDbConf) MasterDb {
-	instance := &fileMasterDb{}
-	instance.loadDb(config.MasterFile)
+	instance := &fileMasterDb{fileName: config.MasterFile}
+	masterMap, err := readMasterFile(config.MasterFile)
+	if err != nil {
+		log.Fatal("MasterFile error: ", err)
+	}
+	instance.masterMap = masterMap
 	return instance
 }
 
-func (db *fileMasterDb) Get(mac string) (MasterDbEntry, bool) {
+func (db *fileMasterDb) Get(mac structs.Mac) (MasterDbEntry, bool) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
 	value, ok := db.masterMap[mac]
 	return value, ok
 }
 
-func (db *fileMasterDb) loadDb(masterFile string) {
+func (db *fileMasterDb) Count() int {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return len(db.masterMap)
+}
+
+func (db *fileMasterDb) FindByVisibility(visibilities ...Visibility) map[structs.Mac]MasterDbEntry {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	result := make(map[structs.Mac]MasterDbEntry)
+	for mac, entry := range db.masterMap {
+		for _, visibility := range visibilities {
+			if entry.Visibility == visibility {
+				result[mac] = entry
+				break
+			}
+		}
+	}
+	return result
+}
+
+func (db *fileMasterDb) Reload() error {
+	masterMap, err := readMasterFile(db.fileName)
+	if err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	before := len(db.masterMap)
+	db.masterMap = masterMap
+	db.lock.Unlock()
+
+	masterLogger.WithFields(log.Fields{
+		"before": before,
+		"after":  len(masterMap),
+	}).Info("MasterFile reloaded.")
+	return nil
+}
+
+// readMasterFile parses and validates the master file
+func readMasterFile(masterFile string) (map[structs.Mac]MasterDbEntry, error) {
 	file, err := ioutil.ReadFile(masterFile)
 	if err != nil {
-		log.Fatal("MasterFile error: ", err)
+		return nil, err
 	}
 
-	var parsed map[string]MasterDbEntry
+	var parsed map[structs.Mac]MasterDbEntry
 	if err = json.Unmarshal(file, &parsed); err != nil {
-		log.Fatal("MasterFile unmarshal err: ", err)
+		return nil, fmt.Errorf("unmarshal err: %s", err)
+	}
+	// the macs are validated and normalized while parsing
+	if parsed == nil {
+		return nil, fmt.Errorf("no entries found")
 	}
 
-	db.masterMap = parsed
+	return parsed, nil
 }
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_fileMasterDb_Reload(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "masterDb")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	masterFile := filepath.Join(dir, "masterDb.json")
+	a.NoError(ioutil.WriteFile(masterFile, []byte(`{"00:00:00:00:00:01": {"name": "printer", "visibility": "ignore"}}`), 0644))
+
+	masterDb := NewMasterDb(conf.MacDbConf{MasterFile: masterFile})
+	a.Equal(1, masterDb.Count())
+
+	a.NoError(ioutil.WriteFile(masterFile, []byte(`{"00:00:00:00:00:01": {"name": "printer", "visibility": "ignore"},
+		"00:00:00:00:00:02": {"name": "ap", "visibility": "infrastructure"}}`), 0644))
+	a.NoError(masterDb.Reload())
+	a.Equal(2, masterDb.Count())
+	entry, ok := masterDb.Get("00:00:00:00:00:02")
+	a.True(ok)
+	a.Equal("ap", entry.Name)
+
+	// the current entries are kept
+	invalid := []string{
+		`{"00:00:00:00:00:01": {"name": "printer", "visibility": "ignore"}`,
+		`{"00:00:00:00:00:01": {"name": "printer", "visibility": "everything"}}`,
+		`{"printer": {"name": "printer", "visibility": "ignore"}}`,
+		`null`,
+	}
+	for _, content := range invalid {
+		a.NoError(ioutil.WriteFile(masterFile, []byte(content), 0644))
+		a.Error(masterDb.Reload(), content)
+		a.Equal(2, masterDb.Count())
+	}
+}
+
+func Test_fileMasterDb_FindByVisibility(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "masterDb")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	masterFile := filepath.Join(dir, "masterDb.json")
+	a.NoError(ioutil.WriteFile(masterFile, []byte(`{"00:00:00:00:00:01": {"name": "printer", "visibility": "ignore"},
+		"00:00:00:00:00:02": {"name": "router", "visibility": "critical-infrastructure"},
+		"00:00:00:00:00:03": {"name": "ap", "visibility": "important-infrastructure"}}`), 0644))
+
+	masterDb := NewMasterDb(conf.MacDbConf{MasterFile: masterFile})
+	found := masterDb.FindByVisibility(VisibilityCriticalInfrastructure, VisibilityImportantInfrastructure)
+	a.Len(found, 2)
+	a.Equal("router", found["00:00:00:00:00:02"].Name)
+	a.Equal("ap", found["00:00:00:00:00:03"].Name)
+
+	a.Len(masterDb.FindByVisibility(VisibilityAll), 0)
+}
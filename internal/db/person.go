@@ -0,0 +1,65 @@
+package db
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dchest/uniuri"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+)
+
+const personIdLength = 16
+
+// Person is the identity behind one or more devices of the user db
+type Person struct {
+	Id                string     `json:"id"`
+	Name              string     `json:"name"`
+	DefaultVisibility Visibility `json:"default-visibility"`
+	// in ms
+	Created int64 `json:"created"`
+	Updated int64 `json:"updated"`
+}
+
+func newPersonId() string {
+	return strings.ToLower(uniuri.NewLen(personIdLength))
+}
+
+// migrateNameEntries converts the entries of the old user db format, grouped only by name, to persons. Names are
+// compared case insensitive and without surrounding spaces. The name and visibility of the latest changed entry is used
+// for the person.
+func migrateNameEntries(entries map[structs.Mac]UserDbEntry, now int64) (map[string]Person, map[structs.Mac]UserDbEntry) {
+	// sorted for a stable result
+	macs := make([]structs.Mac, 0, len(entries))
+	for mac := range entries {
+		macs = append(macs, mac)
+	}
+	sort.Slice(macs, func(i, j int) bool {
+		return macs[i] < macs[j]
+	})
+
+	persons := make(map[string]Person)
+	devices := make(map[structs.Mac]UserDbEntry, len(entries))
+	nameKey2Id := make(map[string]string)
+	for _, mac := range macs {
+		entry := entries[mac]
+		nameKey := strings.ToLower(strings.TrimSpace(entry.Name))
+		id, ok := nameKey2Id[nameKey]
+		if !ok {
+			id = newPersonId()
+			nameKey2Id[nameKey] = id
+			persons[id] = Person{Id: id, Name: strings.TrimSpace(entry.Name), DefaultVisibility: entry.Visibility,
+				Created: now, Updated: entry.Ts}
+		} else if person := persons[id]; entry.Ts > person.Updated {
+			person.Name = strings.TrimSpace(entry.Name)
+			person.DefaultVisibility = entry.Visibility
+			person.Updated = entry.Ts
+			persons[id] = person
+		}
+
+		entry.Name = ""
+		entry.PersonId = id
+		devices[mac] = entry
+	}
+
+	return persons, devices
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_migrateNameEntries(t *testing.T) {
+	a := assert.New(t)
+
+	entries := map[structs.Mac]UserDbEntry{
+		"00:00:00:00:00:01": {Name: "hans", DeviceName: "handy", Visibility: VisibilityUser, Ts: 100},
+		"00:00:00:00:00:02": {Name: " Hans", DeviceName: "laptop", Visibility: VisibilityAll, Ts: 200},
+		"00:00:00:00:00:03": {Name: "olaf", DeviceName: "", Visibility: VisibilityAnon, Ts: 50},
+	}
+	persons, devices := migrateNameEntries(entries, 1000)
+
+	a.Len(persons, 2)
+	a.Len(devices, 3)
+	hansId := devices["00:00:00:00:00:01"].PersonId
+	a.Equal(hansId, devices["00:00:00:00:00:02"].PersonId)
+	a.NotEqual(hansId, devices["00:00:00:00:00:03"].PersonId)
+
+	// the latest entry wins
+	a.Equal(Person{Id: hansId, Name: "Hans", DefaultVisibility: VisibilityAll, Created: 1000, Updated: 200}, persons[hansId])
+	olafId := devices["00:00:00:00:00:03"].PersonId
+	a.Equal(Person{Id: olafId, Name: "olaf", DefaultVisibility: VisibilityAnon, Created: 1000, Updated: 50}, persons[olafId])
+
+	a.Equal(UserDbEntry{PersonId: hansId, DeviceName: "laptop", Visibility: VisibilityAll, Ts: 200}, devices["00:00:00:00:00:02"])
+}
+
+func Test_PersistentUserDb_migration(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "userDb")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	userFile := filepath.Join(dir, "userDb.json")
+	oldContent := []byte(`{"00:00:00:00:00:01": {"name": "hans", "device-name": "handy", "visibility": "all", "ts": 100}}`)
+	a.NoError(ioutil.WriteFile(userFile, oldContent, 0644))
+
+	userDb := NewUserDb(conf.MacDbConf{UserFile: userFile})
+	entry, ok := userDb.Get("00:00:00:00:00:01")
+	a.True(ok)
+	person, ok := userDb.GetPerson(entry.PersonId)
+	a.True(ok)
+	a.Equal("hans", person.Name)
+
+	backup, err := ioutil.ReadFile(userFile + ".v1.bak")
+	a.NoError(err)
+	a.Equal(oldContent, backup)
+
+	var saved userDbFile
+	content, err := ioutil.ReadFile(userFile)
+	a.NoError(err)
+	a.NoError(json.Unmarshal(content, &saved))
+	a.Len(saved.Persons, 1)
+	a.Equal(entry, saved.Devices["00:00:00:00:00:01"])
+
+	// the new format is loaded as it is
+	userDb = NewUserDb(conf.MacDbConf{UserFile: userFile})
+	reloaded, _ := userDb.Get("00:00:00:00:00:01")
+	a.Equal(entry, reloaded)
+}
+
+func Test_PersistentUserDb_deleteOrphanedPerson(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "userDb")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	userFile := filepath.Join(dir, "userDb.json")
+	a.NoError(ioutil.WriteFile(userFile, []byte(`{}`), 0644))
+
+	userDb := NewUserDb(conf.MacDbConf{UserFile: userFile})
+	person := userDb.NewPerson("hans", VisibilityAll)
+	userDb.Set("00:00:00:00:00:01", UserDbEntry{PersonId: person.Id, Visibility: VisibilityAll})
+	userDb.Set("00:00:00:00:00:02", UserDbEntry{PersonId: person.Id, Visibility: VisibilityAll})
+	a.Len(userDb.FindByPerson(person.Id), 2)
+
+	userDb.Delete("00:00:00:00:00:01")
+	_, ok := userDb.GetPerson(person.Id)
+	a.True(ok)
+
+	userDb.Delete("00:00:00:00:00:02")
+	_, ok = userDb.GetPerson(person.Id)
+	a.False(ok)
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the weekday names, the index is the time.Weekday
+var weekdayNames = [...]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// VisibilityRule applies the visibility on the given days from 'from' (inclusive) to 'to' (exclusive). The times are
+// in the format "15:04" of the local time of the space. If 'to' isn't after 'from', the rule lasts until 'to' on the
+// next day, the days are the start days.
+type VisibilityRule struct {
+	Days       []string   `json:"days"`
+	From       string     `json:"from"`
+	To         string     `json:"to"`
+	Visibility Visibility `json:"visibility"`
+}
+
+// VisibilitySchedule is an ordered list of rules, the first matching rule wins.
+type VisibilitySchedule []VisibilityRule
+
+// VisibilityAt returns the visibility of the first rule that matches the given time. The time must be in the local
+// time of the space.
+func (s VisibilitySchedule) VisibilityAt(t time.Time) (Visibility, bool) {
+	for _, rule := range s {
+		if rule.matches(t) {
+			return rule.Visibility, true
+		}
+	}
+	return VisibilityDefault, false
+}
+
+// Validate returns an error for the first invalid rule
+func (s VisibilitySchedule) Validate() error {
+	for i, rule := range s {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+// String returns the text format, see ParseVisibilitySchedule
+func (s VisibilitySchedule) String() string {
+	lines := make([]string, 0, len(s))
+	for _, rule := range s {
+		days := strings.Join(rule.Days, ",")
+		if len(rule.Days) == len(weekdayNames) {
+			days = "*"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s-%s %s", days, rule.From, rule.To, rule.Visibility))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseVisibilitySchedule parses one rule per line in the format "<days> <from>-<to> <visibility>", e.g.
+// "mon,tue 18:00-23:00 all". The days are a comma separated list of day names or ranges (e.g. "mon-fri"), "*" means
+// every day. Empty lines are ignored.
+func ParseVisibilitySchedule(text string) (VisibilitySchedule, error) {
+	var schedule VisibilitySchedule
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected '<days> <from>-<to> <visibility>'", i+1)
+		}
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		times := strings.Split(fields[1], "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("line %d: expected '<from>-<to>'", i+1)
+		}
+
+		rule := VisibilityRule{Days: days, From: times[0], To: times[1], Visibility: Visibility(strings.ToLower(fields[2]))}
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		schedule = append(schedule, rule)
+	}
+	return schedule, nil
+}
+
+func parseDays(value string) ([]string, error) {
+	if value == "*" {
+		return append([]string(nil), weekdayNames[:]...), nil
+	}
+
+	var days []string
+	for _, part := range strings.Split(strings.ToLower(value), ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("invalid days '%s'", part)
+		}
+		first, ok := weekdayIndex(bounds[0])
+		if !ok {
+			return nil, fmt.Errorf("invalid day '%s'", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdayIndex(bounds[1]); !ok {
+				return nil, fmt.Errorf("invalid day '%s'", bounds[1])
+			}
+		}
+		// a range like "sat-sun" wraps around
+		for day := first; ; day = (day + 1) % len(weekdayNames) {
+			days = append(days, weekdayNames[day])
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func weekdayIndex(name string) (int, bool) {
+	for i, weekday := range weekdayNames {
+		if weekday == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (r VisibilityRule) validate() error {
+	if len(r.Days) == 0 {
+		return errors.New("no days given")
+	}
+	for _, day := range r.Days {
+		if _, ok := weekdayIndex(day); !ok {
+			return fmt.Errorf("invalid day '%s'", day)
+		}
+	}
+	if _, err := minuteOfDay(r.From); err != nil {
+		return err
+	}
+	if _, err := minuteOfDay(r.To); err != nil {
+		return err
+	}
+	if !r.Visibility.IsUserVisibility() {
+		return fmt.Errorf("invalid visibility '%s'", r.Visibility)
+	}
+	return nil
+}
+
+func (r VisibilityRule) matches(t time.Time) bool {
+	// the rules are validated on input
+	from, _ := minuteOfDay(r.From)
+	to, _ := minuteOfDay(r.To)
+	minute := t.Hour()*60 + t.Minute()
+
+	if from < to {
+		return minute >= from && minute < to && r.hasDay(t.Weekday())
+	}
+	// over midnight
+	if minute >= from {
+		return r.hasDay(t.Weekday())
+	}
+	if minute < to {
+		return r.hasDay((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (r VisibilityRule) hasDay(weekday time.Weekday) bool {
+	for _, day := range r.Days {
+		if day == weekdayNames[weekday] {
+			return true
+		}
+	}
+	return false
+}
+
+func minuteOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s'", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseVisibilitySchedule(t *testing.T) {
+	a := assert.New(t)
+
+	schedule, err := ParseVisibilitySchedule("tue 18:00-23:59 all\n\n  mon-wed,sat-sun 08:00-18:00 ANON \n* 23:00-06:00 ignore")
+	a.NoError(err)
+	a.Equal(VisibilitySchedule{
+		{Days: []string{"tue"}, From: "18:00", To: "23:59", Visibility: VisibilityAll},
+		{Days: []string{"mon", "tue", "wed", "sat", "sun"}, From: "08:00", To: "18:00", Visibility: VisibilityAnon},
+		{Days: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}, From: "23:00", To: "06:00", Visibility: VisibilityIgnore},
+	}, schedule)
+	a.Equal("tue 18:00-23:59 all\nmon,tue,wed,sat,sun 08:00-18:00 anon\n* 23:00-06:00 ignore", schedule.String())
+
+	schedule, err = ParseVisibilitySchedule(" \n")
+	a.NoError(err)
+	a.Nil(schedule)
+
+	invalid := []string{
+		"tue 18:00-23:59",
+		"tuesday 18:00-23:59 all",
+		"tue 18:00 all",
+		"tue 18:00-24:00 all",
+		"tue 18:00-23:00 critical-infrastructure",
+		"tue 18:00-23:00 everything",
+	}
+	for _, text := range invalid {
+		_, err = ParseVisibilitySchedule(text)
+		a.Error(err, text)
+	}
+}
+
+func Test_VisibilitySchedule_VisibilityAt(t *testing.T) {
+	a := assert.New(t)
+
+	schedule := VisibilitySchedule{
+		{Days: []string{"tue"}, From: "18:00", To: "23:00", Visibility: VisibilityAll},
+		{Days: []string{"fri"}, From: "22:00", To: "02:00", Visibility: VisibilityUser},
+		{Days: []string{"tue", "wed"}, From: "00:00", To: "00:00", Visibility: VisibilityAnon},
+	}
+	// 2019-03-05 is a tuesday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2019, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		time     time.Time
+		expected Visibility
+		ok       bool
+	}{
+		{at(5, 18, 0), VisibilityAll, true},
+		{at(5, 22, 59), VisibilityAll, true},
+		// the whole day
+		{at(5, 23, 0), VisibilityAnon, true},
+		{at(5, 17, 59), VisibilityAnon, true},
+		{at(6, 12, 0), VisibilityAnon, true},
+		{at(7, 12, 0), VisibilityDefault, false},
+		// over midnight
+		{at(8, 21, 59), VisibilityDefault, false},
+		{at(8, 22, 0), VisibilityUser, true},
+		{at(9, 1, 59), VisibilityUser, true},
+		{at(9, 2, 0), VisibilityDefault, false},
+		{at(8, 1, 0), VisibilityDefault, false},
+	}
+
+	for _, test := range tests {
+		visibility, ok := schedule.VisibilityAt(test.time)
+		a.Equal(test.ok, ok, test.time.String())
+		a.Equal(test.expected, visibility, test.time.String())
+	}
+}
@@ -2,31 +2,89 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/internal/metrics"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
 	log "github.com/sirupsen/logrus"
 )
 
 type UserDb interface {
-	Get(mac string) (UserDbEntry, bool)
-	Set(mac string, info UserDbEntry)
-	Delete(mac string)
+	Get(mac structs.Mac) (UserDbEntry, bool)
+	// Set stores the entry, a missing last seen timestamp is set to now. If it had another person before, that person
+	// is removed, if it has no other devices.
+	Set(mac structs.Mac, info UserDbEntry)
+	// Delete removes the entry and its person, if the person has no other devices
+	Delete(mac structs.Mac)
+	// FindByPerson returns all entries (by mac) of the given person
+	FindByPerson(personId string) map[structs.Mac]UserDbEntry
+	// FindByReclaimToken returns the entry with the given (hashed) re-claim token
+	FindByReclaimToken(token string) (structs.Mac, UserDbEntry, bool)
+	GetPerson(id string) (Person, bool)
+	// NewPerson stores a new person with a new id
+	NewPerson(name string, defaultVisibility Visibility) Person
+	// SetPerson changes an existing person
+	SetPerson(person Person)
+	// UpdateLastSeen sets the last seen timestamp (in ms) of the given devices, if they have an entry
+	UpdateLastSeen(macs []structs.Mac, ts int64)
+	// Expire removes the entries not seen since seenBefore (in ms) and returns them, sorted by mac. With dryRun nothing
+	// is changed.
+	Expire(seenBefore int64, dryRun bool) []ExpiredEntry
+	Count() int
+	// Close flushes the db, it must not be used afterwards
+	Close()
 }
 
 type UserDbEntry struct {
-	Name       string     `json:"name"`
+	// only used by the master db and the old user db format, the user db references the person
+	Name       string     `json:"name,omitempty"`
+	PersonId   string     `json:"person-id,omitempty"`
 	DeviceName string     `json:"device-name"`
 	Visibility Visibility `json:"visibility"`
+	// optional, free text in the master db, one of the UserDeviceTypes in the user db
+	DeviceType string `json:"device-type,omitempty"`
+	// optional, a matching rule overrides the visibility
+	Schedule VisibilitySchedule `json:"schedule,omitempty"`
 	// last change in ms
 	Ts int64 `json:"ts"`
+	// in ms, updated with a resolution of lastSeenResolution
+	LastSeen int64 `json:"last-seen,omitempty"`
+	// optional, the hash of a cookie to recognize a device with a randomized mac after the mac changed
+	ReclaimToken string `json:"reclaim-token,omitempty"`
+}
+
+// ExpiredEntry is a removed entry of the user db, as written to the archive file
+type ExpiredEntry struct {
+	Mac    structs.Mac `json:"mac"`
+	Entry  UserDbEntry `json:"entry"`
+	Person Person      `json:"person"`
+	// in ms
+	Expired int64 `json:"expired"`
+}
+
+// the last seen timestamp is only changed (and saved) if the old one is older than this
+const lastSeenResolution = time.Hour
+
+// the file format of the user db
+type userDbFile struct {
+	Persons map[string]Person           `json:"persons"`
+	Devices map[structs.Mac]UserDbEntry `json:"devices"`
 }
 
 type PersistentUserDb struct {
-	userMap map[string]UserDbEntry
+	userMap map[structs.Mac]UserDbEntry
+	persons map[string]Person
 	lock    sync.RWMutex
 	config  conf.MacDbConf
+	// the time of the newest backup
+	lastBackup time.Time
 }
 
 func NewUserDb(config conf.MacDbConf) UserDb {
@@ -35,51 +93,339 @@ func NewUserDb(config conf.MacDbConf) UserDb {
 	return instance
 }
 
-func (db *PersistentUserDb) Get(mac string) (UserDbEntry, bool) {
+func (db *PersistentUserDb) Get(mac structs.Mac) (UserDbEntry, bool) {
 	db.lock.RLock()
 	value, ok := db.userMap[mac]
 	db.lock.RUnlock()
 	return value, ok
 }
 
-func (db *PersistentUserDb) Set(mac string, info UserDbEntry) {
+func (db *PersistentUserDb) FindByPerson(personId string) map[structs.Mac]UserDbEntry {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	result := make(map[structs.Mac]UserDbEntry)
+	for mac, entry := range db.userMap {
+		if entry.PersonId == personId {
+			result[mac] = entry
+		}
+	}
+	return result
+}
+
+func (db *PersistentUserDb) FindByReclaimToken(token string) (structs.Mac, UserDbEntry, bool) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if token == "" {
+		return "", UserDbEntry{}, false
+	}
+	for mac, entry := range db.userMap {
+		if entry.ReclaimToken == token {
+			return mac, entry, true
+		}
+	}
+	return "", UserDbEntry{}, false
+}
+
+func (db *PersistentUserDb) GetPerson(id string) (Person, bool) {
+	db.lock.RLock()
+	value, ok := db.persons[id]
+	db.lock.RUnlock()
+	return value, ok
+}
+
+func (db *PersistentUserDb) NewPerson(name string, defaultVisibility Visibility) Person {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	now := time.Now().Unix() * 1000
+	person := Person{Id: newPersonId(), Name: name, DefaultVisibility: defaultVisibility, Created: now, Updated: now}
+	db.persons[person.Id] = person
+	db.saveDb()
+	metrics.UserDbWrites.WithLabelValues("set-person").Inc()
+	return person
+}
+
+func (db *PersistentUserDb) SetPerson(person Person) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if _, ok := db.persons[person.Id]; !ok {
+		log.WithField("id", person.Id).Error("Unknown person.")
+		return
+	}
+	person.Updated = time.Now().Unix() * 1000
+	db.persons[person.Id] = person
+	db.saveDb()
+	metrics.UserDbWrites.WithLabelValues("set-person").Inc()
+}
+
+func (db *PersistentUserDb) UpdateLastSeen(macs []structs.Mac, ts int64) {
 	db.lock.Lock()
 	defer db.lock.Unlock()
-	db.userMap[mac] = info
+	changed := false
+	for _, mac := range macs {
+		entry, ok := db.userMap[mac]
+		if !ok || ts-entry.LastSeen < int64(lastSeenResolution/time.Millisecond) {
+			continue
+		}
+		entry.LastSeen = ts
+		db.userMap[mac] = entry
+		changed = true
+	}
+	if changed {
+		db.saveDb()
+	}
+}
+
+func (db *PersistentUserDb) Expire(seenBefore int64, dryRun bool) []ExpiredEntry {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	now := time.Now().Unix() * 1000
+	var expired []ExpiredEntry
+	for mac, entry := range db.userMap {
+		if entry.LastSeen < seenBefore {
+			expired = append(expired, ExpiredEntry{Mac: mac, Entry: entry, Person: db.persons[entry.PersonId], Expired: now})
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].Mac < expired[j].Mac
+	})
+	if dryRun || len(expired) == 0 {
+		return expired
+	}
+
+	if db.config.ArchiveFile != "" {
+		if err := appendToArchive(db.config.ArchiveFile, expired); err != nil {
+			log.WithError(err).Error("Can't archive the expired entries, nothing removed.")
+			return nil
+		}
+	}
+	for _, entry := range expired {
+		delete(db.userMap, entry.Mac)
+	}
+	for _, entry := range expired {
+		if entry.Entry.PersonId != "" && !db.hasDevices(entry.Entry.PersonId) {
+			delete(db.persons, entry.Entry.PersonId)
+		}
+	}
 	db.saveDb()
+	metrics.UserDbWrites.WithLabelValues("expire").Add(float64(len(expired)))
+
+	return expired
+}
+
+func appendToArchive(archiveFile string, expired []ExpiredEntry) error {
+	file, err := os.OpenFile(archiveFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, entry := range expired {
+		if err = encoder.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+func (db *PersistentUserDb) Count() int {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return len(db.userMap)
 }
 
-func (db *PersistentUserDb) Delete(mac string) {
+func (db *PersistentUserDb) Set(mac structs.Mac, info UserDbEntry) {
 	db.lock.Lock()
 	defer db.lock.Unlock()
+	before, ok := db.userMap[mac]
+	db.userMap[mac] = withLastSeen(info)
+	if ok && before.PersonId != "" && before.PersonId != info.PersonId && !db.hasDevices(before.PersonId) {
+		delete(db.persons, before.PersonId)
+	}
+	db.saveDb()
+	metrics.UserDbWrites.WithLabelValues("set").Inc()
+}
+
+func (db *PersistentUserDb) Delete(mac structs.Mac) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	entry, ok := db.userMap[mac]
 	delete(db.userMap, mac)
+	if ok && entry.PersonId != "" && !db.hasDevices(entry.PersonId) {
+		delete(db.persons, entry.PersonId)
+	}
 	db.saveDb()
+	metrics.UserDbWrites.WithLabelValues("delete").Inc()
+}
+
+// Close waits for a running write and syncs the file to disk.
+func (db *PersistentUserDb) Close() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	file, err := os.OpenFile(db.config.UserFile, os.O_RDWR, 0644)
+	if err != nil {
+		log.WithError(err).Error("Can't open the userDb for syncing.")
+		return
+	}
+	defer file.Close()
+	if err = file.Sync(); err != nil {
+		log.WithError(err).Error("Can't sync the userDb.")
+	}
+}
+
+// the caller must hold the lock
+func (db *PersistentUserDb) hasDevices(personId string) bool {
+	for _, entry := range db.userMap {
+		if entry.PersonId == personId {
+			return true
+		}
+	}
+	return false
 }
 
 func (db *PersistentUserDb) loadDb() {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	file, err := ioutil.ReadFile(db.config.UserFile)
+	content, parsed, isOld, err := readUserDb(db.config.UserFile)
 	if err != nil {
-		log.Fatal("UserFile error: ", err)
+		log.WithError(err).WithField("file", db.config.UserFile).Error("!!! Can't load the userDb, trying the backups. !!!")
+		content, parsed, isOld, err = db.readBackup()
+		if err != nil {
+			log.Fatal("UserFile error, no valid backup found: ", err)
+		}
+		db.keepCorrupted()
+	}
+	if isOld {
+		parsed = db.migrate(content)
+	}
+	if parsed.Persons == nil {
+		parsed.Persons = make(map[string]Person)
 	}
+	if parsed.Devices == nil {
+		parsed.Devices = make(map[structs.Mac]UserDbEntry)
+	}
+
+	initLastSeen(parsed.Devices, time.Now().Unix()*1000)
+
+	db.userMap = parsed.Devices
+	db.persons = parsed.Persons
+	if info, err := os.Stat(backupFileName(db.config.UserFile, 1)); err == nil {
+		db.lastBackup = info.ModTime()
+	}
+}
 
-	var parsed map[string]UserDbEntry
-	if err = json.Unmarshal(file, &parsed); err != nil {
+// readUserDb returns the content and the parsed file. For the old format, isOld is true and only the content is set.
+func readUserDb(fileName string) (content []byte, parsed userDbFile, isOld bool, err error) {
+	content, err = ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+
+	var raw map[string]json.RawMessage
+	if err = json.Unmarshal(content, &raw); err != nil {
+		return
+	}
+	_, hasDevices := raw["devices"]
+	_, hasPersons := raw["persons"]
+	if !hasDevices && !hasPersons {
+		// the old format, only the entries by mac. Just validate it, it's migrated later.
+		var oldEntries map[structs.Mac]UserDbEntry
+		err = json.Unmarshal(content, &oldEntries)
+		isOld = true
+		return
+	}
+
+	err = json.Unmarshal(content, &parsed)
+	return
+}
+
+// readBackup returns the newest valid backup
+func (db *PersistentUserDb) readBackup() (content []byte, parsed userDbFile, isOld bool, err error) {
+	err = errors.New("no backups configured")
+	for i := 1; i <= db.config.UserFileBackups; i++ {
+		backupFile := backupFileName(db.config.UserFile, i)
+		content, parsed, isOld, err = readUserDb(backupFile)
+		if err == nil {
+			log.WithField("backup", backupFile).Warn("!!! Using the userDb backup, recent changes might be lost. !!!")
+			return
+		}
+		log.WithError(err).WithField("backup", backupFile).Warn("Invalid backup.")
+	}
+	return
+}
+
+// keepCorrupted renames the invalid user file for a later inspection
+func (db *PersistentUserDb) keepCorrupted() {
+	corruptedFile := fmt.Sprintf("%s.corrupted-%d", db.config.UserFile, time.Now().Unix())
+	if err := os.Rename(db.config.UserFile, corruptedFile); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Error("Can't rename the corrupted userDb.")
+		return
+	}
+	log.WithField("file", corruptedFile).Warn("Moved the corrupted userDb.")
+}
+
+// initLastSeen sets the missing last seen timestamps. These entries are from before it was introduced, start with now
+// to not expire them right away.
+func initLastSeen(devices map[structs.Mac]UserDbEntry, now int64) {
+	for mac, entry := range devices {
+		if entry.LastSeen == 0 {
+			entry.LastSeen = now
+			devices[mac] = entry
+		}
+	}
+}
+
+// withLastSeen sets a missing last seen timestamp to now, a new entry must not expire right away
+func withLastSeen(entry UserDbEntry) UserDbEntry {
+	if entry.LastSeen == 0 {
+		entry.LastSeen = time.Now().Unix() * 1000
+	}
+	return entry
+}
+
+// migrate converts the old user db format and saves the old file as backup
+func (db *PersistentUserDb) migrate(file []byte) userDbFile {
+	var oldEntries map[structs.Mac]UserDbEntry
+	if err := json.Unmarshal(file, &oldEntries); err != nil {
 		log.Fatal("UserFile unmarshal err: ", err)
 	}
 
-	db.userMap = parsed
+	backupFile := db.config.UserFile + ".v1.bak"
+	if err := ioutil.WriteFile(backupFile, file, 0644); err != nil {
+		log.Fatal("Can't backup the old userDb: ", err)
+	}
+
+	now := time.Now().Unix() * 1000
+	persons, devices := migrateNameEntries(oldEntries, now)
+	initLastSeen(devices, now)
+	db.persons = persons
+	db.userMap = devices
+	db.saveDb()
+	log.WithFields(log.Fields{"persons": len(persons), "devices": len(devices), "backup": backupFile}).Info("Migrated the userDb to persons.")
+
+	return userDbFile{Persons: persons, Devices: devices}
 }
 
+// saveDb writes the file atomically, i.e. after a crash there is either the old or the new file. Errors are only logged,
+// the data is still in memory and written with the next change.
 func (db *PersistentUserDb) saveDb() {
-	bytes, err := json.MarshalIndent(db.userMap, "", "  ")
+	bytes, err := json.MarshalIndent(userDbFile{Persons: db.persons, Devices: db.userMap}, "", "  ")
 	if err != nil {
-		log.Fatal("Can't marshal the userDb: ", err)
+		log.WithError(err).Error("Can't marshal the userDb.")
+		return
+	}
+
+	if db.config.UserFileBackups > 0 && time.Since(db.lastBackup) >= backupInterval {
+		if err = rotateBackups(db.config.UserFile, db.config.UserFileBackups); err != nil {
+			log.WithError(err).Error("Can't rotate the userDb backups.")
+		} else {
+			db.lastBackup = time.Now()
+		}
 	}
 
-	if err = ioutil.WriteFile(db.config.UserFile, bytes, 0644); err != nil {
-		log.Fatal("Can't save the userDb: ", err)
+	if err = writeFileAtomic(db.config.UserFile, bytes, 0644); err != nil {
+		log.WithError(err).Error("Can't save the userDb.")
 	}
 }
@@ -0,0 +1,280 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+// openUserDb opens the user db of one backend, the first time with the content of the JSON user file
+type openUserDb func(a *assert.Assertions, config conf.MacDbConf) UserDb
+
+func Test_PersistentUserDb_conformance(t *testing.T) {
+	testUserDbConformance(t, func(a *assert.Assertions, config conf.MacDbConf) UserDb {
+		return NewUserDb(config)
+	})
+}
+
+func Test_BoltUserDb_conformance(t *testing.T) {
+	testUserDbConformance(t, func(a *assert.Assertions, config conf.MacDbConf) UserDb {
+		if _, err := os.Stat(config.UserBoltFile); os.IsNotExist(err) {
+			_, err = ImportUserDb(config)
+			a.NoError(err)
+		}
+		return NewBoltUserDb(config)
+	})
+}
+
+// testUserDbConformance runs the tests every UserDb implementation must pass
+func testUserDbConformance(t *testing.T, open openUserDb) {
+	setup := func(t *testing.T, content string) (*assert.Assertions, conf.MacDbConf, func()) {
+		a := assert.New(t)
+		dir, err := ioutil.TempDir("", "userDb")
+		a.NoError(err)
+		config := conf.MacDbConf{UserFile: filepath.Join(dir, "userDb.json"),
+			UserBoltFile: filepath.Join(dir, "userDb.bolt"), ArchiveFile: filepath.Join(dir, "archive.json")}
+		a.NoError(ioutil.WriteFile(config.UserFile, []byte(content), 0644))
+		return a, config, func() { os.RemoveAll(dir) }
+	}
+
+	t.Run("set and reopen", func(t *testing.T) {
+		a, config, cleanup := setup(t, `{"persons": {}, "devices": {}}`)
+		defer cleanup()
+
+		userDb := open(a, config)
+		_, ok := userDb.Get("00:00:00:00:00:01")
+		a.False(ok)
+		a.Equal(0, userDb.Count())
+
+		entry := UserDbEntry{PersonId: "p1", DeviceName: "handy", Visibility: VisibilityAll, Ts: 100, LastSeen: 200,
+			Schedule: VisibilitySchedule{{Days: []string{"mon"}, From: "18:00", To: "20:00", Visibility: VisibilityAnon}}}
+		userDb.Set("00:00:00:00:00:01", entry)
+		stored, ok := userDb.Get("00:00:00:00:00:01")
+		a.True(ok)
+		a.Equal(entry, stored)
+		a.Equal(1, userDb.Count())
+		userDb.Close()
+
+		userDb = open(a, config)
+		defer userDb.Close()
+		stored, _ = userDb.Get("00:00:00:00:00:01")
+		a.Equal(entry, stored)
+	})
+
+	t.Run("persons", func(t *testing.T) {
+		a, config, cleanup := setup(t, `{}`)
+		defer cleanup()
+		userDb := open(a, config)
+		defer userDb.Close()
+
+		person := userDb.NewPerson("hans", VisibilityUser)
+		a.NotEmpty(person.Id)
+		stored, ok := userDb.GetPerson(person.Id)
+		a.True(ok)
+		a.Equal(person, stored)
+
+		person.Name = "olaf"
+		userDb.SetPerson(person)
+		stored, _ = userDb.GetPerson(person.Id)
+		a.Equal("olaf", stored.Name)
+		a.True(stored.Updated >= person.Updated)
+
+		// unknown persons aren't created
+		userDb.SetPerson(Person{Id: "unknown", Name: "nobody"})
+		_, ok = userDb.GetPerson("unknown")
+		a.False(ok)
+	})
+
+	t.Run("find and delete", func(t *testing.T) {
+		a, config, cleanup := setup(t, `{}`)
+		defer cleanup()
+		userDb := open(a, config)
+		defer userDb.Close()
+
+		person := userDb.NewPerson("hans", VisibilityAll)
+		userDb.Set("00:00:00:00:00:01", UserDbEntry{PersonId: person.Id, DeviceName: "a"})
+		userDb.Set("00:00:00:00:00:02", UserDbEntry{PersonId: person.Id, DeviceName: "b"})
+		userDb.Set("00:00:00:00:00:03", UserDbEntry{PersonId: "other", DeviceName: "c"})
+		found := userDb.FindByPerson(person.Id)
+		a.Len(found, 2)
+		a.Equal("b", found["00:00:00:00:00:02"].DeviceName)
+
+		userDb.Delete("00:00:00:00:00:01")
+		_, ok := userDb.Get("00:00:00:00:00:01")
+		a.False(ok)
+		_, ok = userDb.GetPerson(person.Id)
+		a.True(ok)
+
+		// the person without any device is removed
+		userDb.Delete("00:00:00:00:00:02")
+		_, ok = userDb.GetPerson(person.Id)
+		a.False(ok)
+		a.Equal(1, userDb.Count())
+	})
+
+	t.Run("set another person", func(t *testing.T) {
+		a, config, cleanup := setup(t, `{}`)
+		defer cleanup()
+		userDb := open(a, config)
+		defer userDb.Close()
+
+		hans := userDb.NewPerson("hans", VisibilityAll)
+		olaf := userDb.NewPerson("olaf", VisibilityAll)
+		userDb.Set("00:00:00:00:00:01", UserDbEntry{PersonId: hans.Id, DeviceName: "a"})
+		userDb.Set("00:00:00:00:00:02", UserDbEntry{PersonId: hans.Id, DeviceName: "b"})
+		userDb.Set("00:00:00:00:00:03", UserDbEntry{PersonId: olaf.Id, DeviceName: "c"})
+
+		userDb.Set("00:00:00:00:00:01", UserDbEntry{PersonId: olaf.Id, DeviceName: "a"})
+		_, ok := userDb.GetPerson(hans.Id)
+		a.True(ok)
+
+		// the last device of hans
+		userDb.Set("00:00:00:00:00:02", UserDbEntry{PersonId: olaf.Id, DeviceName: "b"})
+		_, ok = userDb.GetPerson(hans.Id)
+		a.False(ok)
+		_, ok = userDb.GetPerson(olaf.Id)
+		a.True(ok)
+		a.Len(userDb.FindByPerson(olaf.Id), 3)
+	})
+
+	t.Run("reclaim token", func(t *testing.T) {
+		a, config, cleanup := setup(t, `{}`)
+		defer cleanup()
+		userDb := open(a, config)
+		defer userDb.Close()
+
+		userDb.Set("00:00:00:00:00:01", UserDbEntry{DeviceName: "a"})
+		userDb.Set("06:00:00:00:00:02", UserDbEntry{DeviceName: "b", ReclaimToken: "token"})
+		mac, entry, ok := userDb.FindByReclaimToken("token")
+		a.True(ok)
+		a.Equal(structs.Mac("06:00:00:00:00:02"), mac)
+		a.Equal("b", entry.DeviceName)
+
+		_, _, ok = userDb.FindByReclaimToken("other")
+		a.False(ok)
+		_, _, ok = userDb.FindByReclaimToken("")
+		a.False(ok)
+	})
+
+	t.Run("last seen", func(t *testing.T) {
+		before := time.Now().Unix() * 1000
+		a, config, cleanup := setup(t, `{"persons": {}, "devices": {
+			"00:00:00:00:00:01": {"device-name": "a", "last-seen": 1000},
+			"00:00:00:00:00:02": {"device-name": "b"}
+		}}`)
+		defer cleanup()
+		userDb := open(a, config)
+		defer userDb.Close()
+
+		// missing values are initialized with now
+		entry, _ := userDb.Get("00:00:00:00:00:02")
+		a.True(entry.LastSeen >= before)
+		userDb.Set("00:00:00:00:00:03", UserDbEntry{DeviceName: "new"})
+		entry, _ = userDb.Get("00:00:00:00:00:03")
+		a.True(entry.LastSeen >= before)
+		expired := userDb.Expire(before, true)
+		a.Len(expired, 1)
+		a.Equal(structs.Mac("00:00:00:00:00:01"), expired[0].Mac)
+
+		hour := int64(time.Hour / time.Millisecond)
+		userDb.UpdateLastSeen([]structs.Mac{"00:00:00:00:00:01", "00:00:00:00:00:09"}, 1000+hour-1)
+		entry, _ = userDb.Get("00:00:00:00:00:01")
+		a.Equal(int64(1000), entry.LastSeen)
+
+		userDb.UpdateLastSeen([]structs.Mac{"00:00:00:00:00:01"}, 1000+hour)
+		entry, _ = userDb.Get("00:00:00:00:00:01")
+		a.Equal(1000+hour, entry.LastSeen)
+		_, ok := userDb.Get("00:00:00:00:00:09")
+		a.False(ok)
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		a, config, cleanup := setup(t, `{"persons": {
+			"p1": {"id": "p1", "name": "hans"},
+			"p2": {"id": "p2", "name": "olaf"}
+		}, "devices": {
+			"00:00:00:00:00:01": {"person-id": "p1", "device-name": "old", "last-seen": 1000},
+			"00:00:00:00:00:02": {"person-id": "p1", "device-name": "new", "last-seen": 5000},
+			"00:00:00:00:00:03": {"person-id": "p2", "device-name": "old", "last-seen": 2000}
+		}}`)
+		defer cleanup()
+		userDb := open(a, config)
+		defer userDb.Close()
+
+		expired := userDb.Expire(3000, true)
+		a.Len(expired, 2)
+		a.Equal(structs.Mac("00:00:00:00:00:01"), expired[0].Mac)
+		a.Equal("hans", expired[0].Person.Name)
+		a.Equal(structs.Mac("00:00:00:00:00:03"), expired[1].Mac)
+		// nothing changed
+		a.Equal(3, userDb.Count())
+		_, err := os.Stat(config.ArchiveFile)
+		a.True(os.IsNotExist(err))
+
+		expired = userDb.Expire(3000, false)
+		a.Len(expired, 2)
+		a.Equal(1, userDb.Count())
+		_, ok := userDb.Get("00:00:00:00:00:02")
+		a.True(ok)
+		_, ok = userDb.GetPerson("p1")
+		a.True(ok)
+		// without any device
+		_, ok = userDb.GetPerson("p2")
+		a.False(ok)
+
+		archive, err := os.Open(config.ArchiveFile)
+		a.NoError(err)
+		defer archive.Close()
+		var archived []ExpiredEntry
+		scanner := bufio.NewScanner(archive)
+		for scanner.Scan() {
+			var entry ExpiredEntry
+			a.NoError(json.Unmarshal(scanner.Bytes(), &entry))
+			archived = append(archived, entry)
+		}
+		a.Equal(expired, archived)
+	})
+}
+
+func Test_ImportExportUserDb(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "userDb")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	config := conf.MacDbConf{UserFile: filepath.Join(dir, "userDb.json"), UserBoltFile: filepath.Join(dir, "userDb.bolt")}
+	// the old format is migrated
+	a.NoError(ioutil.WriteFile(config.UserFile, []byte(`{
+		"00:00:00:00:00:01": {"name": "hans", "device-name": "handy", "visibility": "all", "ts": 100},
+		"00:00:00:00:00:02": {"name": "hans", "device-name": "laptop", "visibility": "all", "ts": 200}
+	}`), 0644))
+
+	count, err := ImportUserDb(config)
+	a.NoError(err)
+	a.Equal(2, count)
+	// only into an empty db
+	_, err = ImportUserDb(config)
+	a.Error(err)
+
+	_, err = ExportUserDb(config, false)
+	a.Error(err)
+	count, err = ExportUserDb(config, true)
+	a.NoError(err)
+	a.Equal(2, count)
+
+	_, exported, isOld, err := readUserDb(config.UserFile)
+	a.NoError(err)
+	a.False(isOld)
+	a.Len(exported.Persons, 1)
+	a.Len(exported.Devices, 2)
+	personId := exported.Devices["00:00:00:00:00:01"].PersonId
+	a.Equal("hans", exported.Persons[personId].Name)
+	a.Equal(personId, exported.Devices["00:00:00:00:00:02"].PersonId)
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	log "github.com/sirupsen/logrus"
+)
+
+// the expiry runs in this interval
+const expiryInterval = 24 * time.Hour
+
+var expiryLogger = log.WithField("where", "userDbExpiry")
+
+// UserDbExpiry removes the user db entries not seen for the configured amount of months, once at start and then once a
+// day.
+type UserDbExpiry struct {
+	userDb UserDb
+	months int
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func NewUserDbExpiry(config conf.MacDbConf, userDb UserDb) *UserDbExpiry {
+	expiry := &UserDbExpiry{userDb: userDb, months: config.ExpireAfterInMonths, stop: make(chan struct{}),
+		done: make(chan struct{})}
+	expiryLogger.WithField("months", expiry.months).Info("Enable the user db expiry.")
+
+	go expiry.loop()
+
+	return expiry
+}
+
+// SeenBefore returns the last seen timestamp (in ms), all entries seen before are expired.
+func SeenBefore(now time.Time, months int) int64 {
+	return now.AddDate(0, -months, 0).Unix() * 1000
+}
+
+// Stop ends the expiry and waits for a running one
+func (e *UserDbExpiry) Stop() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *UserDbExpiry) loop() {
+	defer close(e.done)
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		expired := e.userDb.Expire(SeenBefore(time.Now(), e.months), false)
+		for _, entry := range expired {
+			expiryLogger.WithFields(log.Fields{
+				"mac":      entry.Mac,
+				"lastSeen": time.Unix(entry.Entry.LastSeen/1000, 0),
+			}).Info("Expired user db entry.")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-e.stop:
+			return
+		}
+	}
+}
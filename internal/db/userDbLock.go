@@ -0,0 +1,40 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+)
+
+// userDbLockFile returns the lock file next to the file of the configured backend
+func userDbLockFile(config conf.MacDbConf) string {
+	if config.UserBackend == UserBackendBolt {
+		return config.UserBoltFile + ".lock"
+	}
+	return config.UserFile + ".lock"
+}
+
+// LockUserDb takes the lock of the user db, thus no other process can change the file while the running service holds
+// the entries in memory. The lock is released by the returned function or when the process exits. Fails immediately,
+// if another process holds the lock.
+func LockUserDb(config conf.MacDbConf) (func(), error) {
+	lockFile := userDbLockFile(config)
+	file, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is locked by another process", lockFile)
+		}
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
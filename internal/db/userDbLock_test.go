@@ -0,0 +1,36 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LockUserDb(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "userDbLock")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	config := conf.MacDbConf{UserFile: filepath.Join(dir, "userDb.json"), UserBoltFile: filepath.Join(dir, "userDb.bolt")}
+
+	unlock, err := LockUserDb(config)
+	a.NoError(err)
+	_, err = LockUserDb(config)
+	a.Error(err)
+
+	// the other backend has its own lock
+	boltConfig := config
+	boltConfig.UserBackend = UserBackendBolt
+	unlockBolt, err := LockUserDb(boltConfig)
+	a.NoError(err)
+	unlockBolt()
+
+	unlock()
+	unlock, err = LockUserDb(config)
+	a.NoError(err)
+	unlock()
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	UserBackendJson = "json"
+	UserBackendBolt = "bolt"
+)
+
+// OpenUserDb returns the user db of the configured backend
+func OpenUserDb(config conf.MacDbConf) UserDb {
+	switch config.UserBackend {
+	case "", UserBackendJson:
+		return NewUserDb(config)
+	case UserBackendBolt:
+		return NewBoltUserDb(config)
+	}
+	log.WithField("userBackend", config.UserBackend).Fatal("Unknown userBackend.")
+	return nil
+}
+
+// ImportUserDb copies the JSON user file into the bolt file and returns the amount of devices. The bolt file must not
+// contain any data.
+func ImportUserDb(config conf.MacDbConf) (int, error) {
+	content, parsed, isOld, err := readUserDb(config.UserFile)
+	if err != nil {
+		return 0, err
+	}
+	if isOld {
+		var oldEntries map[structs.Mac]UserDbEntry
+		if err = json.Unmarshal(content, &oldEntries); err != nil {
+			return 0, err
+		}
+		parsed.Persons, parsed.Devices = migrateNameEntries(oldEntries, time.Now().Unix()*1000)
+	}
+	initLastSeen(parsed.Devices, time.Now().Unix()*1000)
+
+	boltDb, err := openBolt(config.UserBoltFile)
+	if err != nil {
+		return 0, err
+	}
+	defer boltDb.Close()
+
+	err = boltDb.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(personsBucket).Stats().KeyN > 0 || tx.Bucket(devicesBucket).Stats().KeyN > 0 {
+			return fmt.Errorf("%s isn't empty", config.UserBoltFile)
+		}
+		for id, person := range parsed.Persons {
+			if err := putJson(tx.Bucket(personsBucket), id, person); err != nil {
+				return err
+			}
+		}
+		for mac, entry := range parsed.Devices {
+			if err := putJson(tx.Bucket(devicesBucket), mac.String(), entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(parsed.Devices), nil
+}
+
+// ExportUserDb writes the content of the bolt file to the JSON user file and returns the amount of devices. An existing
+// user file is only replaced with overwrite.
+func ExportUserDb(config conf.MacDbConf, overwrite bool) (int, error) {
+	if _, err := os.Stat(config.UserFile); err == nil && !overwrite {
+		return 0, fmt.Errorf("%s already exists", config.UserFile)
+	}
+	if _, err := os.Stat(config.UserBoltFile); err != nil {
+		return 0, err
+	}
+
+	boltDb, err := openBolt(config.UserBoltFile)
+	if err != nil {
+		return 0, err
+	}
+	defer boltDb.Close()
+
+	exported := userDbFile{Persons: make(map[string]Person), Devices: make(map[structs.Mac]UserDbEntry)}
+	err = boltDb.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(personsBucket).ForEach(func(key, value []byte) error {
+			var person Person
+			if err := json.Unmarshal(value, &person); err != nil {
+				return err
+			}
+			exported.Persons[string(key)] = person
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return forEachDevice(tx, func(mac structs.Mac, entry UserDbEntry) {
+			exported.Devices[mac] = entry
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	bytes, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err = writeFileAtomic(config.UserFile, bytes, 0644); err != nil {
+		return 0, err
+	}
+	return len(exported.Devices), nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SeenBefore(t *testing.T) {
+	now := time.Date(2019, 3, 31, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2018, 12, 31, 12, 0, 0, 0, time.UTC).Unix()*1000, SeenBefore(now, 3))
+}
+
+func Test_PersistentUserDb_backups(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "userDb")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	config := conf.MacDbConf{UserFile: filepath.Join(dir, "userDb.json"), UserFileBackups: 2}
+	a.NoError(ioutil.WriteFile(config.UserFile, []byte(`{"persons": {}, "devices": {}}`), 0644))
+	userDb := NewUserDb(config).(*PersistentUserDb)
+
+	for i := 1; i <= 3; i++ {
+		// force a new backup
+		userDb.lastBackup = time.Time{}
+		userDb.Set(structs.Mac(fmt.Sprintf("00:00:00:00:00:0%d", i)), UserDbEntry{DeviceName: "a", LastSeen: 1})
+	}
+
+	countEntries := func(fileName string) int {
+		_, parsed, _, err := readUserDb(fileName)
+		a.NoError(err)
+		return len(parsed.Devices)
+	}
+	a.Equal(3, countEntries(config.UserFile))
+	a.Equal(2, countEntries(config.UserFile+".1"))
+	a.Equal(1, countEntries(config.UserFile+".2"))
+	_, err = os.Stat(config.UserFile + ".3")
+	a.True(os.IsNotExist(err))
+
+	// no new backup within the interval
+	userDb.Set("00:00:00:00:00:04", UserDbEntry{DeviceName: "a", LastSeen: 1})
+	a.Equal(2, countEntries(config.UserFile+".1"))
+
+	// no temp files left
+	files, err := ioutil.ReadDir(dir)
+	a.NoError(err)
+	a.Len(files, 3)
+}
+
+func Test_PersistentUserDb_corrupted(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "userDb")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	config := conf.MacDbConf{UserFile: filepath.Join(dir, "userDb.json"), UserFileBackups: 2}
+	a.NoError(ioutil.WriteFile(config.UserFile, []byte(`{"persons": {}, "devi`), 0644))
+	a.NoError(ioutil.WriteFile(config.UserFile+".1", []byte(``), 0644))
+	a.NoError(ioutil.WriteFile(config.UserFile+".2", []byte(`{"persons": {}, "devices": {"00:00:00:00:00:01": {}}}`), 0644))
+
+	userDb := NewUserDb(config)
+	_, ok := userDb.Get("00:00:00:00:00:01")
+	a.True(ok)
+
+	// the corrupted file is kept
+	corrupted, err := filepath.Glob(config.UserFile + ".corrupted-*")
+	a.NoError(err)
+	a.Len(corrupted, 1)
+}
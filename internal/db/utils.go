@@ -1,14 +1,14 @@
 package db
 
-import "strconv"
+import "github.com/ktt-ol/spaceDevices/pkg/structs"
 
-// IsMacLocallyAdministered expects the mac in the format e.g. "20:c9:d0:7a:fa:31"
+// IsMacLocallyAdministered expects the mac in any format of structs.ParseMac, e.g. "20:c9:d0:7a:fa:31". Returns false for
+// invalid macs.
 // https://en.wikipedia.org/wiki/MAC_address
 func IsMacLocallyAdministered(mac string) bool {
-	// 00000010
-	const mask = 1 << 1
-
-	first2chars := mac[:2]
-	decimal, _ := strconv.ParseInt(first2chars, 16, 64)
-	return (decimal & mask) == mask
+	parsed, err := structs.ParseMac(mac)
+	if err != nil {
+		return false
+	}
+	return parsed.IsLocallyAdministered()
 }
@@ -11,4 +11,7 @@ func Test_IsMacLocallyAdministered(t *testing.T) {
 	assert.False(t, IsMacLocallyAdministered("20:c9:d0:7a:fa:31"))
 
 	assert.True(t, IsMacLocallyAdministered("d4:38:9c:01:dd:03"))
+	// any format, no panic for invalid macs
+	assert.True(t, IsMacLocallyAdministered("62-01-0F-B5-F2-D9"))
+	assert.False(t, IsMacLocallyAdministered("2"))
 }
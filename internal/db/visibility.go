@@ -26,6 +26,9 @@ const (
 	VisibilityImportantInfrastructure Visibility = "important-infrastructure"
 
 	VisibilityCriticalInfrastructure Visibility = "critical-infrastructure"
+
+	// VisibilityDefault is only used for the devices of the user db, the default visibility of the person applies
+	VisibilityDefault Visibility = ""
 )
 
 // don't forget all Visibilities to this array...
@@ -37,12 +40,38 @@ func (v *Visibility) UnmarshalJSON(byteValue []byte) error {
 		return errors.New("Visibility must be a JSON string value.")
 	}
 	value := Visibility(byteValue[1 : len(byteValue)-1])
+	if value == VisibilityDefault || value.IsValid() {
+		*v = value
+		return nil
+	}
+
+	return fmt.Errorf("Visibility was '%s' but must be one of %s", value, validVsibilities)
+}
+
+// IsValid returns true for all visibilities except VisibilityDefault
+func (v Visibility) IsValid() bool {
 	for _, validV := range validVsibilities {
-		if validV == value {
-			*v = value
-			return nil
+		if validV == v {
+			return true
 		}
 	}
+	return false
+}
 
-	return fmt.Errorf("Visibility was '%s' but must be one of %s", value, validVsibilities)
+// IsUserVisibility returns true for the visibilities a user can choose for the own devices, the infrastructure
+// visibilities are reserved for the master db
+func (v Visibility) IsUserVisibility() bool {
+	switch v {
+	case VisibilityIgnore, VisibilityAnon, VisibilityUser, VisibilityAll:
+		return true
+	}
+	return false
+}
+
+// EffectiveVisibility returns the visibility of the device, if set, otherwise the default of the person
+func EffectiveVisibility(deviceVisibility Visibility, personDefault Visibility) Visibility {
+	if deviceVisibility != VisibilityDefault {
+		return deviceVisibility
+	}
+	return personDefault
 }
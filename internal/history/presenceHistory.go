@@ -0,0 +1,390 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/sirupsen/logrus"
+)
+
+// the history file is rewritten without the expired events at most once in this interval
+const compactionInterval = time.Hour
+
+// the events are held in memory for the queries, without any configured limit only this amount of the newest events
+// is kept
+const defaultMaxEvents = 500000
+
+// between the compactions the events may exceed maxEvents by this fraction, thus the file isn't rewritten on every
+// update once the limit is reached
+const maxEventsSlack = 10
+
+var logger = logrus.WithField("where", "history")
+
+type EventType string
+
+const (
+	// EventSessionStart is recorded when a mac appears in the session list
+	EventSessionStart EventType = "session-start"
+	// EventSessionEnd is recorded when a mac is gone from the session list
+	EventSessionEnd EventType = "session-end"
+	// EventArrived is recorded when a (visible) person appears
+	EventArrived EventType = "arrived"
+	// EventLeft is recorded when a (visible) person is gone
+	EventLeft EventType = "left"
+)
+
+// Event is a single entry of the presence history, stored as one JSON line in the history file.
+type Event struct {
+	// unix time in ms
+	Ts   int64     `json:"ts"`
+	Type EventType `json:"type"`
+	Mac  string    `json:"mac,omitempty"`
+	Name string    `json:"name,omitempty"`
+}
+
+func (e Event) Time() time.Time {
+	return time.Unix(0, e.Ts*int64(time.Millisecond))
+}
+
+// PresenceHistory records the session start/end per mac and the arrive/leave events per person in an append-only
+// file. Only the names of the given people are stored, thus the caller must filter the people by their visibility.
+type PresenceHistory struct {
+	config         conf.HistoryConf
+	lock           sync.RWMutex
+	file           *os.File
+	events         []Event
+	activeMacs     map[string]bool
+	activeNames    map[string]bool
+	lastCompaction time.Time
+}
+
+func NewPresenceHistory(config conf.HistoryConf) *PresenceHistory {
+	if config.RetentionInDays < 1 && config.MaxEvents < 1 {
+		logger.WithField("maxEvents", defaultMaxEvents).Warn("No history limit configured, using the default maxEvents.")
+		config.MaxEvents = defaultMaxEvents
+	}
+	h := &PresenceHistory{config: config, activeMacs: make(map[string]bool), activeNames: make(map[string]bool)}
+	h.loadHistory()
+	h.lock.Lock()
+	h.compact(time.Now())
+	h.lock.Unlock()
+	return h
+}
+
+// Update compares the given sessions and people with the last known state and records the differences.
+func (h *PresenceHistory) Update(now time.Time, sessions []structs.WifiSession, people []structs.Person) {
+	macs := make(map[string]bool)
+	for _, session := range sessions {
+		macs[session.Mac.String()] = true
+	}
+	names := make(map[string]bool)
+	for _, person := range people {
+		if len(person.Name) > 0 {
+			names[person.Name] = true
+		}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ts := toMs(now)
+	newEvents := diffEvents(h.activeMacs, macs, func(mac string, started bool) Event {
+		if started {
+			return Event{Ts: ts, Type: EventSessionStart, Mac: mac}
+		}
+		return Event{Ts: ts, Type: EventSessionEnd, Mac: mac}
+	})
+	newEvents = append(newEvents, diffEvents(h.activeNames, names, func(name string, started bool) Event {
+		if started {
+			return Event{Ts: ts, Type: EventArrived, Name: name}
+		}
+		return Event{Ts: ts, Type: EventLeft, Name: name}
+	})...)
+	h.activeMacs = macs
+	h.activeNames = names
+
+	if len(newEvents) > 0 {
+		h.events = append(h.events, newEvents...)
+		h.appendToFile(newEvents)
+	}
+
+	if now.Sub(h.lastCompaction) > compactionInterval || h.exceedsMaxEvents() {
+		h.compact(now)
+	}
+}
+
+// exceedsMaxEvents returns true, if the events must be compacted before the next regular compaction. The caller must
+// hold the lock.
+func (h *PresenceHistory) exceedsMaxEvents() bool {
+	if h.config.MaxEvents < 1 {
+		return false
+	}
+	return len(h.events) > h.config.MaxEvents+h.config.MaxEvents/maxEventsSlack
+}
+
+// Events returns all events between from and to (both inclusive).
+func (h *PresenceHistory) Events(from time.Time, to time.Time) []Event {
+	fromTs, toTs := toMs(from), toMs(to)
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	result := make([]Event, 0)
+	for _, e := range h.events {
+		if e.Ts >= fromTs && e.Ts <= toTs {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// LastArrival returns the time of the last arrival of the given person.
+func (h *PresenceHistory) LastArrival(name string) (time.Time, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for i := len(h.events) - 1; i >= 0; i-- {
+		e := h.events[i]
+		if e.Type == EventArrived && e.Name == name {
+			return e.Time(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// PeopleBetween returns the sorted names of all people who were present at any time between from and to.
+func (h *PresenceHistory) PeopleBetween(from time.Time, to time.Time) []string {
+	fromTs, toTs := toMs(from), toMs(to)
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	present := make(map[string]bool)
+	found := make(map[string]bool)
+	for _, e := range h.events {
+		if e.Ts > toTs {
+			break
+		}
+		switch e.Type {
+		case EventArrived:
+			present[e.Name] = true
+			if e.Ts >= fromTs {
+				found[e.Name] = true
+			}
+		case EventLeft:
+			if present[e.Name] && e.Ts >= fromTs {
+				found[e.Name] = true
+			}
+			delete(present, e.Name)
+		}
+	}
+	for name := range present {
+		found[name] = true
+	}
+
+	return sortedKeys(found)
+}
+
+func (h *PresenceHistory) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+}
+
+func (h *PresenceHistory) loadHistory() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	file, err := os.Open(h.config.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logger.WithField("file", h.config.File).Info("No history file found, starting a new one.")
+			return
+		}
+		logger.WithError(err).Fatal("History file error.")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// e.g. a partially written line after a power loss
+			logger.WithError(err).WithField("line", scanner.Text()).Warn("Skipping invalid history line.")
+			continue
+		}
+		h.events = append(h.events, e)
+		h.replay(e)
+	}
+	if err := scanner.Err(); err != nil {
+		logger.WithError(err).Fatal("Could not read history file.")
+	}
+}
+
+func (h *PresenceHistory) replay(e Event) {
+	switch e.Type {
+	case EventSessionStart:
+		h.activeMacs[e.Mac] = true
+	case EventSessionEnd:
+		delete(h.activeMacs, e.Mac)
+	case EventArrived:
+		h.activeNames[e.Name] = true
+	case EventLeft:
+		delete(h.activeNames, e.Name)
+	}
+}
+
+// compact removes the expired events and rewrites the history file. The start events of the still active macs and
+// people are kept, otherwise their state would be lost after a restart. The caller must hold the lock.
+func (h *PresenceHistory) compact(now time.Time) {
+	h.lastCompaction = now
+
+	var minTs int64
+	if h.config.RetentionInDays > 0 {
+		minTs = toMs(now.AddDate(0, 0, -h.config.RetentionInDays))
+	}
+	minIndex := 0
+	if h.config.MaxEvents > 0 && len(h.events) > h.config.MaxEvents {
+		minIndex = len(h.events) - h.config.MaxEvents
+	}
+
+	lastStart := make(map[string]int)
+	for i, e := range h.events {
+		if e.Type == EventSessionStart && h.activeMacs[e.Mac] {
+			lastStart["mac:"+e.Mac] = i
+		} else if e.Type == EventArrived && h.activeNames[e.Name] {
+			lastStart["name:"+e.Name] = i
+		}
+	}
+	keepAnyway := make(map[int]bool)
+	for _, i := range lastStart {
+		keepAnyway[i] = true
+	}
+
+	kept := make([]Event, 0, len(h.events))
+	for i, e := range h.events {
+		if (i >= minIndex && e.Ts >= minTs) || keepAnyway[i] {
+			kept = append(kept, e)
+		}
+	}
+
+	if h.file != nil && len(kept) == len(h.events) {
+		// nothing expired
+		return
+	}
+	h.events = kept
+	h.rewriteFile()
+}
+
+func (h *PresenceHistory) rewriteFile() {
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+
+	tmpFile := h.config.File + ".tmp"
+	file, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		logger.WithError(err).Error("Can't create the temporary history file.")
+		return
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, e := range h.events {
+		if err = encoder.Encode(e); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err == nil {
+		err = os.Rename(tmpFile, h.config.File)
+	}
+	if err != nil {
+		logger.WithError(err).Error("Can't rewrite the history file.")
+		os.Remove(tmpFile)
+	}
+
+	h.openFile()
+}
+
+func (h *PresenceHistory) openFile() {
+	file, err := os.OpenFile(h.config.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		logger.WithError(err).Error("Can't open the history file.")
+		return
+	}
+	h.file = file
+}
+
+func (h *PresenceHistory) appendToFile(events []Event) {
+	if h.file == nil {
+		h.openFile()
+		if h.file == nil {
+			return
+		}
+	}
+
+	encoder := json.NewEncoder(h.file)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			logger.WithError(err).Error("Can't write to the history file.")
+			return
+		}
+	}
+}
+
+// diffEvents creates an event for every key that was added or removed. The events are sorted by key, started before
+// stopped.
+func diffEvents(before map[string]bool, after map[string]bool, newEvent func(key string, started bool) Event) []Event {
+	started := make(map[string]bool)
+	for key := range after {
+		if !before[key] {
+			started[key] = true
+		}
+	}
+	stopped := make(map[string]bool)
+	for key := range before {
+		if !after[key] {
+			stopped[key] = true
+		}
+	}
+
+	events := make([]Event, 0, len(started)+len(stopped))
+	for _, key := range sortedKeys(started) {
+		events = append(events, newEvent(key, true))
+	}
+	for _, key := range sortedKeys(stopped) {
+		events = append(events, newEvent(key, false))
+	}
+	return events
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func toMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
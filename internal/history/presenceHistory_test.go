@@ -0,0 +1,182 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_updateAndQuery(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	h := NewPresenceHistory(conf.HistoryConf{File: filepath.Join(dir, "history.log")})
+	defer h.Close()
+
+	t0 := time.Date(2019, 3, 5, 18, 0, 0, 0, time.UTC)
+	h.Update(t0, sessions("01", "02"), people("hans"))
+	h.Update(t0.Add(time.Hour), sessions("02", "03"), people("hans", "olaf"))
+	h.Update(t0.Add(2*time.Hour), sessions("03"), people("olaf"))
+	h.Update(t0.Add(3*time.Hour), sessions(), people())
+
+	events := h.Events(t0, t0)
+	assert.Equal([]Event{
+		{Ts: toMs(t0), Type: EventSessionStart, Mac: "00:00:00:00:00:01"},
+		{Ts: toMs(t0), Type: EventSessionStart, Mac: "00:00:00:00:00:02"},
+		{Ts: toMs(t0), Type: EventArrived, Name: "hans"},
+	}, events)
+	assert.Equal(10, len(h.Events(t0, t0.Add(3*time.Hour))))
+
+	arrival, ok := h.LastArrival("olaf")
+	assert.True(ok)
+	assert.Equal(toMs(t0.Add(time.Hour)), toMs(arrival))
+	_, ok = h.LastArrival("herman")
+	assert.False(ok)
+
+	assert.Equal([]string{"hans"}, h.PeopleBetween(t0, t0.Add(30*time.Minute)))
+	assert.Equal([]string{"hans", "olaf"}, h.PeopleBetween(t0.Add(90*time.Minute), t0.Add(100*time.Minute)))
+	assert.Equal([]string{"olaf"}, h.PeopleBetween(t0.Add(150*time.Minute), t0.Add(5*time.Hour)))
+	assert.Equal([]string{}, h.PeopleBetween(t0.Add(4*time.Hour), t0.Add(5*time.Hour)))
+}
+
+func Test_surviveRestart(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	config := conf.HistoryConf{File: filepath.Join(dir, "history.log")}
+
+	t0 := time.Date(2019, 3, 5, 18, 0, 0, 0, time.UTC)
+	h := NewPresenceHistory(config)
+	h.Update(t0, sessions("01"), people("hans"))
+	h.Close()
+
+	h = NewPresenceHistory(config)
+	defer h.Close()
+	assert.Equal(2, len(h.Events(t0, t0)))
+
+	// still there, thus no new events
+	h.Update(t0.Add(time.Minute), sessions("01"), people("hans"))
+	assert.Equal(2, len(h.Events(t0, t0.Add(time.Minute))))
+
+	h.Update(t0.Add(2*time.Minute), sessions(), people())
+	assert.Equal([]Event{
+		{Ts: toMs(t0.Add(2 * time.Minute)), Type: EventSessionEnd, Mac: "00:00:00:00:00:01"},
+		{Ts: toMs(t0.Add(2 * time.Minute)), Type: EventLeft, Name: "hans"},
+	}, h.Events(t0.Add(time.Minute), t0.Add(2*time.Minute)))
+}
+
+func Test_retention(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	config := conf.HistoryConf{File: filepath.Join(dir, "history.log"), RetentionInDays: 10}
+
+	now := time.Now()
+	h := NewPresenceHistory(config)
+	h.Update(now.AddDate(0, 0, -20), sessions("01", "02"), people("hans"))
+	h.Update(now.AddDate(0, 0, -19), sessions("01"), people())
+	h.Update(now, sessions("01", "03"), people())
+	h.Close()
+
+	h = NewPresenceHistory(config)
+	defer h.Close()
+	events := h.Events(now.AddDate(-1, 0, 0), now)
+	// the start of the still active mac 01 is kept
+	assert.Equal([]Event{
+		{Ts: toMs(now.AddDate(0, 0, -20)), Type: EventSessionStart, Mac: "00:00:00:00:00:01"},
+		{Ts: toMs(now), Type: EventSessionStart, Mac: "00:00:00:00:00:03"},
+	}, events)
+}
+
+func Test_compactInMemory(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// without any limit, the default applies
+	h := NewPresenceHistory(conf.HistoryConf{File: filepath.Join(dir, "unlimited.log")})
+	assert.Equal(defaultMaxEvents, h.config.MaxEvents)
+	h.Close()
+
+	h = NewPresenceHistory(conf.HistoryConf{File: filepath.Join(dir, "history.log"), MaxEvents: 2})
+	defer h.Close()
+	t0 := time.Now()
+	h.Update(t0, sessions("01"), people())
+	h.Update(t0.Add(time.Minute), sessions(), people())
+	h.Update(t0.Add(2*time.Minute), sessions("02"), people())
+	// the next compaction removes the expired events from memory, too
+	h.Update(t0.Add(2*time.Hour), sessions("02", "03"), people())
+	assert.Equal([]Event{
+		{Ts: toMs(t0.Add(2 * time.Minute)), Type: EventSessionStart, Mac: "00:00:00:00:00:02"},
+		{Ts: toMs(t0.Add(2 * time.Hour)), Type: EventSessionStart, Mac: "00:00:00:00:00:03"},
+	}, h.Events(t0.Add(-time.Hour), t0.Add(3*time.Hour)))
+}
+
+func Test_maxEventsBetweenCompactions(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	h := NewPresenceHistory(conf.HistoryConf{File: filepath.Join(dir, "history.log"), MaxEvents: 20})
+	defer h.Close()
+	t0 := time.Now()
+	// a flapping device within the compaction interval
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			h.Update(t0.Add(time.Duration(i)*time.Second), sessions("01"), people())
+		} else {
+			h.Update(t0.Add(time.Duration(i)*time.Second), sessions(), people())
+		}
+		assert.True(len(h.events) <= 22, "%d events after %d updates", len(h.events), i+1)
+	}
+	// the newest events are kept
+	assert.Equal([]Event{{Ts: toMs(t0.Add(99 * time.Second)), Type: EventSessionEnd, Mac: "00:00:00:00:00:01"}},
+		h.Events(t0.Add(99*time.Second), t0.Add(99*time.Second)))
+}
+
+func Test_skipInvalidLines(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.log")
+
+	content := `{"ts":1000,"type":"arrived","name":"hans"}
+{"ts":2000,"type":"le`
+	assert.NoError(ioutil.WriteFile(file, []byte(content), 0644))
+
+	h := NewPresenceHistory(conf.HistoryConf{File: file})
+	defer h.Close()
+	assert.Equal(1, len(h.Events(time.Unix(0, 0), time.Unix(10, 0))))
+	assert.Equal([]string{"hans"}, h.PeopleBetween(time.Unix(5, 0), time.Unix(10, 0)))
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func sessions(lastMacs ...string) []structs.WifiSession {
+	result := make([]structs.WifiSession, 0, len(lastMacs))
+	for _, lastMac := range lastMacs {
+		result = append(result, structs.WifiSession{Mac: structs.Mac("00:00:00:00:00:" + lastMac)})
+	}
+	return result
+}
+
+func people(names ...string) []structs.Person {
+	result := make([]structs.Person, 0, len(names))
+	for _, name := range names {
+		result = append(result, structs.Person{Name: name})
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Don't use any names of people or devices as label values, the metrics are not protected by the visibility.
+
+const namespace = "spacedevices"
+
+var (
+	PeopleCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "people",
+		Help:      "Number of people (including anonymous ones) as published.",
+	})
+	DeviceCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "devices",
+		Help:      "Number of devices as published.",
+	})
+	UnknownDevicesCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "unknown_devices",
+		Help:      "Number of devices without an entry in the master or user db.",
+	})
+	UnknownRandomizedDevicesCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "unknown_randomized_devices",
+		Help:      "Number of unknown devices with a randomized (locally administered) mac.",
+	})
+	DevicesPerLocation = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "location_devices",
+		Help:      "Number of devices per location.",
+	}, []string{"location"})
+	DevicesPerType = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "type_devices",
+		Help:      "Number of devices per device type of the master db.",
+	}, []string{"type"})
+	InfrastructureOffline = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "infrastructure_offline",
+		Help:      "Number of offline critical and important infrastructure devices, by visibility.",
+	}, []string{"visibility"})
+	InfrastructureAlerts = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "infrastructure_alerts",
+		Help:      "Number of critical infrastructure devices missing for longer than the alert time.",
+	})
+
+	SessionMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_messages_total",
+		Help:      "Number of messages received from the session topic.",
+	})
+	SessionParseErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_parse_errors_total",
+		Help:      "Number of session messages that couldn't be unmarshalled.",
+	})
+	MqttPublishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_publishes_total",
+		Help:      "Number of publishes of the devices, by result (success or failure).",
+	}, []string{"result"})
+	UserDbWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "userdb_writes_total",
+		Help:      "Number of changes to the user db, by operation (set, delete, set-person or expire).",
+	}, []string{"operation"})
+	PoweredWarnings = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "powered_warnings_total",
+		Help:      "Number of warnings about powered devices while the space is closed.",
+	})
+	DeviceReclaims = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "device_reclaims_total",
+		Help:      "Number of devices recognized by the re-claim cookie after their randomized mac changed.",
+	})
+)
+
+// UnknownLocation is the label value for devices without a location
+const UnknownLocation = "unknown"
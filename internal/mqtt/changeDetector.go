@@ -0,0 +1,71 @@
+package mqtt
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+)
+
+// changeDetector decides whether the PeopleAndDevices must be published. It compares the canonical form of the data,
+// i.e. the order of the people and devices doesn't matter.
+type changeDetector struct {
+	// a value < 1 disables the periodic republish
+	heartbeat  time.Duration
+	lastSent   *structs.PeopleAndDevices
+	lastSendTs time.Time
+}
+
+func newChangeDetector(heartbeat time.Duration) *changeDetector {
+	return &changeDetector{heartbeat: heartbeat}
+}
+
+// shouldSend returns true if the data differs from the last sent data or the heartbeat interval is over.
+func (c *changeDetector) shouldSend(now time.Time, data structs.PeopleAndDevices) bool {
+	if c.lastSent == nil {
+		return true
+	}
+	if c.heartbeat > 0 && now.Sub(c.lastSendTs) >= c.heartbeat {
+		return true
+	}
+
+	return !reflect.DeepEqual(*c.lastSent, canonicalPeopleAndDevices(data))
+}
+
+// sent must be called after the data was published successfully.
+func (c *changeDetector) sent(now time.Time, data structs.PeopleAndDevices) {
+	canonical := canonicalPeopleAndDevices(data)
+	c.lastSent = &canonical
+	c.lastSendTs = now
+}
+
+// reset forgets the last sent data, i.e. the next data is always sent.
+func (c *changeDetector) reset() {
+	c.lastSent = nil
+}
+
+// canonicalPeopleAndDevices returns a sorted deep copy, nil slices are replaced with empty ones.
+func canonicalPeopleAndDevices(data structs.PeopleAndDevices) structs.PeopleAndDevices {
+	canonical := data
+	canonical.People = make([]structs.Person, 0, len(data.People))
+	for _, person := range data.People {
+		devices := make([]structs.Devices, len(person.Devices))
+		copy(devices, person.Devices)
+		sort.Slice(devices, func(i, j int) bool {
+			if devices[i].Name != devices[j].Name {
+				return devices[i].Name < devices[j].Name
+			}
+			if devices[i].Location != devices[j].Location {
+				return devices[i].Location < devices[j].Location
+			}
+			return devices[i].Type < devices[j].Type
+		})
+		canonical.People = append(canonical.People, structs.Person{Name: person.Name, Devices: devices})
+	}
+	sort.SliceStable(canonical.People, func(i, j int) bool {
+		return canonical.People[i].Name < canonical.People[j].Name
+	})
+
+	return canonical
+}
@@ -0,0 +1,97 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_changeDetector_ordering(t *testing.T) {
+	assert := assert.New(t)
+	t0 := time.Date(2019, 3, 5, 18, 0, 0, 0, time.UTC)
+	c := newChangeDetector(0)
+
+	data := structs.PeopleAndDevices{
+		People: []structs.Person{
+			{Name: "olaf", Devices: []structs.Devices{{Name: "mac", Location: "Bar"}, {Name: "mac", Location: "Club"}}},
+			{Name: "hans"},
+		},
+		PeopleCount: 3, DeviceCount: 5, UnknownDevicesCount: 1,
+	}
+	assert.True(c.shouldSend(t0, data))
+	c.sent(t0, data)
+	assert.False(c.shouldSend(t0, data))
+
+	// other order of people and (equally named) devices
+	reordered := structs.PeopleAndDevices{
+		People: []structs.Person{
+			{Name: "hans", Devices: []structs.Devices{}},
+			{Name: "olaf", Devices: []structs.Devices{{Name: "mac", Location: "Club"}, {Name: "mac", Location: "Bar"}}},
+		},
+		PeopleCount: 3, DeviceCount: 5, UnknownDevicesCount: 1,
+	}
+	assert.False(c.shouldSend(t0, reordered))
+
+	// the sent data is a copy
+	data.People[0].Devices[0].Location = "Space"
+	assert.False(c.shouldSend(t0, reordered))
+
+	// devices that only differ in the type
+	typed := structs.PeopleAndDevices{People: []structs.Person{{Name: "olaf", Devices: []structs.Devices{
+		{Name: "mac", Location: "Bar", Type: "phone"}, {Name: "mac", Location: "Bar", Type: "laptop"}}}}}
+	c.sent(t0, typed)
+	typed.People[0].Devices[0], typed.People[0].Devices[1] = typed.People[0].Devices[1], typed.People[0].Devices[0]
+	assert.False(c.shouldSend(t0, typed))
+}
+
+func Test_changeDetector_changes(t *testing.T) {
+	assert := assert.New(t)
+	t0 := time.Date(2019, 3, 5, 18, 0, 0, 0, time.UTC)
+	c := newChangeDetector(0)
+
+	data := structs.PeopleAndDevices{
+		People:      []structs.Person{{Name: "olaf", Devices: []structs.Devices{{Name: "mac", Location: "Bar"}}}},
+		PeopleCount: 1, DeviceCount: 1,
+	}
+	c.sent(t0, data)
+
+	changed := data
+	changed.UnknownDevicesCount = 1
+	assert.True(c.shouldSend(t0, changed))
+
+	changed = data
+	changed.People = []structs.Person{{Name: "olaf", Devices: []structs.Devices{{Name: "mac", Location: "Club"}}}}
+	assert.True(c.shouldSend(t0, changed))
+
+	changed = data
+	changed.People = []structs.Person{{Name: "olaf"}}
+	assert.True(c.shouldSend(t0, changed))
+
+	changed = data
+	changed.People = []structs.Person{}
+	assert.True(c.shouldSend(t0, changed))
+
+	// the old md5 check was blind for this: the same %v representation
+	c.sent(t0, structs.PeopleAndDevices{People: []structs.Person{{Name: "a b"}}})
+	assert.True(c.shouldSend(t0, structs.PeopleAndDevices{People: []structs.Person{{Name: "a"}, {Name: "b"}}}))
+}
+
+func Test_changeDetector_heartbeat(t *testing.T) {
+	assert := assert.New(t)
+	t0 := time.Date(2019, 3, 5, 18, 0, 0, 0, time.UTC)
+	c := newChangeDetector(15 * time.Minute)
+
+	data := structs.PeopleAndDevices{People: []structs.Person{}}
+	c.sent(t0, data)
+	assert.False(c.shouldSend(t0.Add(14*time.Minute), data))
+	assert.True(c.shouldSend(t0.Add(15*time.Minute), data))
+	c.sent(t0.Add(15*time.Minute), data)
+	assert.False(c.shouldSend(t0.Add(16*time.Minute), data))
+
+	// disabled
+	c = newChangeDetector(0)
+	c.sent(t0, data)
+	assert.False(c.shouldSend(t0.Add(24*time.Hour), data))
+}
@@ -1,18 +1,19 @@
 package mqtt
 
 import (
-	"encoding/json"
-
 	"github.com/ktt-ol/spaceDevices/internal/conf"
 	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/internal/history"
+	"github.com/ktt-ol/spaceDevices/internal/metrics"
+	"github.com/ktt-ol/spaceDevices/internal/sources"
 
-	"bytes"
-	"crypto/md5"
-	"fmt"
 	"github.com/ktt-ol/spaceDevices/pkg/structs"
 	"github.com/sirupsen/logrus"
+	"reflect"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 var ignoredVisibility = [...]db.Visibility{db.VisibilityCriticalInfrastructure, db.VisibilityImportantInfrastructure,
@@ -20,41 +21,177 @@ var ignoredVisibility = [...]db.Visibility{db.VisibilityCriticalInfrastructure,
 
 var ddLogger = logrus.WithField("where", "deviceData")
 
+// the service status is sent in this interval
+const statusInterval = time.Minute
+
+// the heartbeat is checked in this interval, i.e. it's sent at most this late
+const heartbeatCheckInterval = time.Minute
+
+// devicesEntry collects the visible devices of one person. The result doesn't depend on the order of the devices.
 type devicesEntry struct {
-	hideName    bool
-	showDevices bool
-	devices     []structs.Devices
+	name string
+	// true, if at least one device is anonymous
+	hideName bool
+	// only the devices with VisibilityAll
+	devices []structs.Devices
 }
 
 type DeviceData struct {
-	locations       []conf.Location
-	mqttHandler     *MqttHandler
-	masterDb        db.MasterDb
-	userDb          db.UserDb
+	locations []conf.Location
+	// the schedules of the visibility are evaluated in this time zone
+	timezone    *time.Location
+	mqttHandler *MqttHandler
+	masterDb    db.MasterDb
+	userDb      db.UserDb
+	history     *history.PresenceHistory
+	debouncer   *sessionDebouncer
+	// nil, if the infrastructure isn't watched
+	infrastructure *infrastructureWatcher
+	// nil, if no powered warnings are sent
+	poweredWarner *poweredWarner
+	// the last sent device type summary, nil if nothing was sent
+	lastDeviceTypes map[string]uint16
+
+	// guards the wifiSessionList and the lastPeopleAndDevices, they are read by the web service
+	lock sync.RWMutex
+	// the merged sessions of all sources
 	wifiSessionList []structs.WifiSession
 
-	lastSentHash []byte
+	changeDetector *changeDetector
+	// nil until the first sessions arrived
+	lastPeopleAndDevices *structs.PeopleAndDevices
+
+	// new locations, the current sessions are evaluated again
+	reloads chan []conf.Location
+	stop    chan struct{}
+	// closed when the update loop is done, nil if not listening
+	stopped chan struct{}
+}
 
-	// more to come, e.g. LanSessions
+type sourceUpdate struct {
+	index    int
+	sessions []structs.WifiSession
 }
 
-func NewDeviceData(locations []conf.Location, mqttHandler *MqttHandler, masterDb db.MasterDb, userDb db.UserDb) *DeviceData {
-	dd := DeviceData{locations: locations, mqttHandler: mqttHandler, masterDb: masterDb, userDb: userDb}
+// NewDeviceData creates a new instance. The presenceHistory is optional and can be nil, the timezone defaults to the
+// local time zone.
+func NewDeviceData(locations []conf.Location, timezone *time.Location, debounceConf conf.DebounceConf, mqttHandler *MqttHandler,
+	masterDb db.MasterDb, userDb db.UserDb, presenceHistory *history.PresenceHistory) *DeviceData {
+	dd := DeviceData{locations: locations, timezone: timezone, mqttHandler: mqttHandler, masterDb: masterDb, userDb: userDb, history: presenceHistory,
+		changeDetector: newChangeDetector(mqttHandler.heartbeat), reloads: make(chan []conf.Location), stop: make(chan struct{})}
+	if debounceConf.ArrivalDelayInMinutes > 0 || debounceConf.GracePeriodInMinutes > 0 {
+		dd.debouncer = newSessionDebouncer(debounceConf)
+	}
+	if mqttHandler.infrastructureTopic != "" {
+		dd.infrastructure = newInfrastructureWatcher(mqttHandler.infrastructureAlert)
+	}
+	if mqttHandler.spaceStateTopic != "" && mqttHandler.poweredWarningTopic != "" {
+		dd.poweredWarner = newPoweredWarner(mqttHandler.poweredWarningRepeat)
+	}
 	return &dd
 }
 
-func (d *DeviceData) ListenAndUpdatePeopleData() {
+// ListenAndUpdatePeopleData merges the sessions of all given sources and updates the people data on every change. For
+// the same mac, the session of the first source wins.
+func (d *DeviceData) ListenAndUpdatePeopleData(sessionSources []sources.SessionSource) {
+	updates := make(chan sourceUpdate)
+	for i, source := range sessionSources {
+		ddLogger.WithField("source", source.Name()).Info("Listening for sessions.")
+		go func(index int, source sources.SessionSource) {
+			for {
+				select {
+				case sessions := <-source.Sessions():
+					select {
+					case updates <- sourceUpdate{index: index, sessions: sessions}:
+					case <-d.stop:
+						return
+					}
+				case <-d.stop:
+					return
+				}
+			}
+		}(i, source)
+	}
+
+	d.stopped = make(chan struct{})
 	go func() {
+		defer close(d.stopped)
+		statusTicker := time.NewTicker(statusInterval)
+		defer statusTicker.Stop()
+		// nil, if the heartbeat is disabled
+		var heartbeat <-chan time.Time
+		if d.changeDetector.heartbeat > 0 {
+			heartbeatTicker := time.NewTicker(heartbeatCheckInterval)
+			defer heartbeatTicker.Stop()
+			heartbeat = heartbeatTicker.C
+		}
+		sessionsPerSource := make([][]structs.WifiSession, len(sessionSources))
 		for {
-			data := <-d.mqttHandler.GetNewDataChannel()
-			d.newData(data)
+			select {
+			case update := <-updates:
+				sessionsPerSource[update.index] = update.sessions
+				d.newSessions(sources.Merge(sessionsPerSource...))
+			case locations := <-d.reloads:
+				d.locations = locations
+				// nothing to evaluate before the first sessions arrived
+				if d.lastPeopleAndDevices != nil {
+					d.newSessions(d.wifiSessionList)
+				}
+			case closed := <-d.mqttHandler.spaceStates:
+				if d.poweredWarner != nil {
+					d.poweredWarner.setClosed(time.Now(), closed)
+					d.checkPoweredDevices(d.wifiSessionList)
+				}
+			case <-statusTicker.C:
+				d.mqttHandler.sendStatus(d.GetStatus())
+				// the missing devices are alerted and the powered warnings repeated even if no new sessions arrive, e.g.
+				// while all sources are quiet
+				if d.infrastructure != nil {
+					d.updateInfrastructure(d.wifiSessionList)
+				}
+				if d.poweredWarner != nil {
+					d.checkPoweredDevices(d.wifiSessionList)
+				}
+			case <-heartbeat:
+				d.sendHeartbeat()
+			case <-d.stop:
+				return
+			}
 		}
 	}()
 }
 
+// GetStatus returns the current state of this service
+func (d *DeviceData) GetStatus() structs.ServiceStatus {
+	status := d.mqttHandler.status()
+	status.MasterDbEntries = d.masterDb.Count()
+	status.UserDbEntries = d.userDb.Count()
+	return status
+}
+
+// Reload replaces the locations and evaluates the current sessions again, e.g. after the master db was reloaded. Does
+// nothing, if not listening.
+func (d *DeviceData) Reload(locations []conf.Location) {
+	if d.stopped == nil {
+		return
+	}
+	select {
+	case d.reloads <- locations:
+	case <-d.stop:
+	}
+}
+
+// Stop ends the processing of new sessions and waits for a running update.
+func (d *DeviceData) Stop() {
+	close(d.stop)
+	if d.stopped != nil {
+		<-d.stopped
+	}
+}
+
 func (d *DeviceData) GetOneEntry() []structs.WifiSession {
 	data := <-d.mqttHandler.GetNewDataChannel()
-	sessionsList := d.unmarshal(data)
+	sessionsList := unmarshalWifiSessions(data)
 	if sessionsList == nil {
 		return nil
 	}
@@ -76,42 +213,166 @@ func (d *DeviceData) GetOneEntry() []structs.WifiSession {
 	return unknownSession
 }
 
-func (d *DeviceData) newData(data []byte) {
-	sessionsList, peopleAndDevices, ok := d.parseWifiSessions(data)
-	if ok {
-		d.wifiSessionList = sessionsList
-		if ddLogger.Logger.Level >= logrus.DebugLevel {
-			peopleList := make([]string, 0, len(peopleAndDevices.People))
-			for _, person := range peopleAndDevices.People {
-				if len(person.Name) == 0 {
-					continue
-				}
-				personStr := person.Name + " ["
-				for _, device := range person.Devices {
-					personStr += device.Name + ","
-				}
-				personStr += "]"
-				peopleList = append(peopleList, personStr)
+func (d *DeviceData) newSessions(sessionsList []structs.WifiSession) {
+	presentSessions, peopleAndDevices := d.calculatePresent(sessionsList)
+	d.updateMetrics(presentSessions, peopleAndDevices)
+	// only this go routine writes, thus reading without the lock is fine
+	before := d.lastPeopleAndDevices
+	d.lock.Lock()
+	d.wifiSessionList = sessionsList
+	d.lastPeopleAndDevices = &peopleAndDevices
+	d.lock.Unlock()
+	macs := make([]structs.Mac, 0, len(sessionsList))
+	for _, session := range sessionsList {
+		macs = append(macs, session.Mac)
+	}
+	d.userDb.UpdateLastSeen(macs, toMs(time.Now()))
+	if d.history != nil {
+		// the people list contains only the visible names
+		d.history.Update(time.Now(), sessionsList, peopleAndDevices.People)
+	}
+	if ddLogger.Logger.Level >= logrus.DebugLevel {
+		peopleList := make([]string, 0, len(peopleAndDevices.People))
+		for _, person := range peopleAndDevices.People {
+			if len(person.Name) == 0 {
+				continue
+			}
+			personStr := person.Name + " ["
+			for _, device := range person.Devices {
+				personStr += device.Name + ","
 			}
-			sort.Strings(peopleList)
-			ddLogger.Debugf("PeopleCount: %d, DeviceCount: %d, UnknownDevicesCount: %d, Persons: %s",
-				peopleAndDevices.PeopleCount, peopleAndDevices.DeviceCount, peopleAndDevices.UnknownDevicesCount, strings.Join(peopleList, "; "))
+			personStr += "]"
+			peopleList = append(peopleList, personStr)
 		}
-		h := md5.New()
-		s := fmt.Sprintf("%v", peopleAndDevices)
-		hash := h.Sum([]byte(s))
-		if bytes.Equal(hash, d.lastSentHash) {
-			ddLogger.Debug("Nothing changed in people count, skipping mqtt")
-		} else {
-			d.mqttHandler.SendPeopleAndDevices(peopleAndDevices)
-			d.lastSentHash = hash
+		sort.Strings(peopleList)
+		ddLogger.Debugf("PeopleCount: %d, DeviceCount: %d, UnknownDevicesCount: %d, Persons: %s",
+			peopleAndDevices.PeopleCount, peopleAndDevices.DeviceCount, peopleAndDevices.UnknownDevicesCount, strings.Join(peopleList, "; "))
+	}
+	if before != nil {
+		events := diffPeopleAndDevices(*before, peopleAndDevices, toMs(time.Now()))
+		if len(events) > 0 {
+			d.mqttHandler.SendPresenceEvents(events)
+		}
+	}
+
+	if d.infrastructure != nil {
+		d.updateInfrastructure(sessionsList)
+	}
+	if d.poweredWarner != nil {
+		d.checkPoweredDevices(sessionsList)
+	}
+	if d.mqttHandler.deviceTypesTopic != "" {
+		d.sendDeviceTypes(peopleAndDevices.DeviceTypes)
+	}
+
+	now := time.Now()
+	if d.mqttHandler.takeStale() {
+		ddLogger.Info("Got sessions again, replacing the stale devices.")
+		d.changeDetector.reset()
+	}
+	if !d.changeDetector.shouldSend(now, peopleAndDevices) {
+		ddLogger.Debug("Nothing changed in people count, skipping mqtt")
+	} else if d.mqttHandler.SendPeopleAndDevices(peopleAndDevices) {
+		d.changeDetector.sent(now, peopleAndDevices)
+	}
+}
+
+// sendHeartbeat publishes the last data again, if the heartbeat interval is over without new sessions. Nothing is
+// sent while the stale devices are published, the next sessions replace them.
+func (d *DeviceData) sendHeartbeat() {
+	if d.lastPeopleAndDevices == nil || d.mqttHandler.isStale() {
+		return
+	}
+	now := time.Now()
+	if d.changeDetector.shouldSend(now, *d.lastPeopleAndDevices) && d.mqttHandler.SendPeopleAndDevices(*d.lastPeopleAndDevices) {
+		d.changeDetector.sent(now, *d.lastPeopleAndDevices)
+	}
+}
+
+// updateInfrastructure publishes the changed status and the alerts of the watched infrastructure. The sessions are not
+// debounced, the infrastructure has its own alert time.
+func (d *DeviceData) updateInfrastructure(sessionsList []structs.WifiSession) {
+	entries := d.masterDb.FindByVisibility(watchedVisibility[:]...)
+	status, changed, events := d.infrastructure.update(time.Now(), entries, sessionsList)
+	if changed {
+		d.mqttHandler.SendInfrastructureStatus(status)
+	}
+	if len(events) > 0 {
+		d.mqttHandler.SendInfrastructureEvents(events)
+	}
+}
+
+// sendDeviceTypes publishes the summary, if the counts changed since the last successful publish
+func (d *DeviceData) sendDeviceTypes(types map[string]uint16) {
+	if types == nil {
+		types = make(map[string]uint16)
+	}
+	if d.lastDeviceTypes != nil && reflect.DeepEqual(d.lastDeviceTypes, types) {
+		return
+	}
+	if d.mqttHandler.SendDeviceTypes(structs.DeviceTypeSummary{Types: types, Ts: toMs(time.Now())}) {
+		d.lastDeviceTypes = types
+	}
+}
+
+// checkPoweredDevices sends a warning, if devices with the powered-while-closed-warning are online while the space is
+// closed. The sessions are not debounced.
+func (d *DeviceData) checkPoweredDevices(sessionsList []structs.WifiSession) {
+	var devices []structs.Devices
+	for _, session := range sessionsList {
+		if entry, ok := d.masterDb.Get(session.Mac); ok && entry.PoweredWhileClosedWarning {
+			devices = append(devices, structs.Devices{Name: masterEntryName(entry), Location: d.resolveLocation(session)})
+		}
+	}
+	if warning, ok := d.poweredWarner.check(time.Now(), devices); ok {
+		ddLogger.WithField("devices", len(warning.Devices)).Warn("Devices are powered while the space is closed.")
+		metrics.PoweredWarnings.Inc()
+		d.mqttHandler.SendPoweredWarning(warning)
+	}
+}
+
+func (d *DeviceData) updateMetrics(presentSessions []structs.WifiSession, peopleAndDevices structs.PeopleAndDevices) {
+	metrics.PeopleCount.Set(float64(peopleAndDevices.PeopleCount))
+	metrics.DeviceCount.Set(float64(peopleAndDevices.DeviceCount))
+	metrics.UnknownDevicesCount.Set(float64(peopleAndDevices.UnknownDevicesCount))
+	metrics.UnknownRandomizedDevicesCount.Set(float64(peopleAndDevices.UnknownRandomizedDevicesCount))
+
+	perLocation := make(map[string]int)
+	for _, location := range d.locations {
+		perLocation[location.Name] = 0
+	}
+	for _, session := range presentSessions {
+		location := d.resolveLocation(session)
+		if location == "" {
+			location = metrics.UnknownLocation
 		}
+		perLocation[location]++
+	}
+	metrics.DevicesPerLocation.Reset()
+	for location, count := range perLocation {
+		metrics.DevicesPerLocation.WithLabelValues(location).Set(float64(count))
+	}
+	metrics.DevicesPerType.Reset()
+	for deviceType, count := range peopleAndDevices.DeviceTypes {
+		metrics.DevicesPerType.WithLabelValues(deviceType).Set(float64(count))
+	}
+}
 
+// GetPeopleAndDevices returns the currently visible people and devices, as published to the devices topic. The result
+// is empty until the first sessions arrived.
+func (d *DeviceData) GetPeopleAndDevices() structs.PeopleAndDevices {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.lastPeopleAndDevices == nil {
+		return structs.PeopleAndDevices{People: []structs.Person{}}
 	}
+	return *d.lastPeopleAndDevices
 }
 
 // finds the session entry for the given ip v4 or v6 address
 func (d *DeviceData) GetByIp(ip string) (structs.WifiSession, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
 	if strings.Count(ip, ":") < 2 {
 		// v4
 		for _, v := range d.wifiSessionList {
@@ -135,30 +396,35 @@ func (d *DeviceData) GetByIp(ip string) (structs.WifiSession, bool) {
 	return structs.WifiSession{}, false
 }
 
-func (d *DeviceData) unmarshal(rawData []byte) []structs.WifiSession {
-	var sessionData []structs.WifiSession
-	if err := json.Unmarshal(rawData, &sessionData); err != nil {
-		ddLogger.WithFields(logrus.Fields{
-			"rawData": string(rawData),
-			"error":   err,
-		}).Error("Unable to unmarshal wifi session json.")
-		return nil
-	}
-
-	return sessionData
-}
-
 func (d *DeviceData) parseWifiSessions(rawData []byte) (sessionsList []structs.WifiSession, peopleAndDevices structs.PeopleAndDevices, success bool) {
-	sessionData := d.unmarshal(rawData)
+	sessionData := unmarshalWifiSessions(rawData)
 	if sessionData == nil {
 		return
 	}
+	sessionsList = sessionData
+	_, peopleAndDevices = d.calculatePresent(sessionData)
+	success = true
+	return
+}
 
-	username2DevicesMap := make(map[string]*devicesEntry)
-SESSION_LOOP:
-	for _, wifiSession := range sessionData {
-		sessionsList = append(sessionsList, wifiSession)
+// calculatePresent returns the sessions that count as present and the resulting people and devices
+func (d *DeviceData) calculatePresent(sessions []structs.WifiSession) ([]structs.WifiSession, structs.PeopleAndDevices) {
+	now := time.Now()
+	// the debounced sessions contain recently disappeared and lack the just arrived devices
+	presentSessions := sessions
+	if d.debouncer != nil {
+		presentSessions = d.debouncer.filter(now, sessions)
+	}
 
+	return presentSessions, d.calculatePeopleAndDevices(now, presentSessions)
+}
+
+// calculatePeopleAndDevices aggregates the sessions, the visibility schedules are evaluated for the given time
+func (d *DeviceData) calculatePeopleAndDevices(now time.Time, sessions []structs.WifiSession) (peopleAndDevices structs.PeopleAndDevices) {
+	localNow := now.In(d.localTimezone())
+	person2DevicesMap := make(map[string]*devicesEntry)
+SESSION_LOOP:
+	for _, wifiSession := range sessions {
 		peopleAndDevices.DeviceCount++
 		var userInfo db.UserDbEntry
 		masterDbEntry, ok := d.masterDb.Get(wifiSession.Mac)
@@ -169,75 +435,100 @@ SESSION_LOOP:
 			if !ok {
 				// nothing found for this mac
 				peopleAndDevices.UnknownDevicesCount++
+				if wifiSession.Mac.IsLocallyAdministered() {
+					peopleAndDevices.UnknownRandomizedDevicesCount++
+				}
 				continue
 			}
 		}
+		personKey, name, visibility := d.resolvePerson(userInfo, localNow)
+		// an ignored device doesn't count for its person, the person might be present with another device
+		if visibility == db.VisibilityIgnore {
+			continue
+		}
+		// the infrastructure isn't shown, but its types are counted
+		if userInfo.DeviceType != "" {
+			if peopleAndDevices.DeviceTypes == nil {
+				peopleAndDevices.DeviceTypes = make(map[string]uint16)
+			}
+			peopleAndDevices.DeviceTypes[userInfo.DeviceType]++
+		}
 		for _, v := range ignoredVisibility {
-			if v == userInfo.Visibility {
+			if v == visibility {
 				continue SESSION_LOOP
 			}
 		}
 
-		entry, ok := username2DevicesMap[userInfo.Name]
+		entry, ok := person2DevicesMap[personKey]
 		if !ok {
-			entry = &devicesEntry{}
-			username2DevicesMap[userInfo.Name] = entry
-		}
-
-		location := wifiSession.Location
-		if len(location) == 0 {
-			// location attribute not set, resolve the location by the access point id
-			location = d.findLocation(wifiSession.AP)
-		}
-		device := structs.Devices{Name: userInfo.DeviceName, Location: location}
-		entry.devices = append(entry.devices, device)
-
-		if userInfo.Visibility == db.VisibilityIgnore {
-			entry.hideName = true
-			continue
-		}
-
-		if len(entry.devices) == 1 {
+			entry = &devicesEntry{name: name}
+			person2DevicesMap[personKey] = entry
 			peopleAndDevices.PeopleCount++
 		}
 
-		if userInfo.Visibility == db.VisibilityAnon {
+		switch visibility {
+		case db.VisibilityAnon:
+			// the most restrictive visibility wins for the name
 			entry.hideName = true
-			continue
-		}
-
-		if userInfo.Visibility == db.VisibilityUser {
-			entry.showDevices = false
-			continue
-		}
-
-		if userInfo.Visibility == db.VisibilityAll {
-			entry.showDevices = true
-			continue
+		case db.VisibilityAll:
+			device := structs.Devices{Name: userInfo.DeviceName, Location: d.resolveLocation(wifiSession), Type: userInfo.DeviceType}
+			entry.devices = append(entry.devices, device)
 		}
+		// VisibilityUser shows only the name
 	}
 
 	peopleAndDevices.People = make([]structs.Person, 0, 10)
-	for username, devicesEntry := range username2DevicesMap {
+	for _, devicesEntry := range person2DevicesMap {
 		if devicesEntry.hideName {
 			continue
 		}
 
-		var person structs.Person
-		if devicesEntry.showDevices {
-			person = structs.Person{Name: username, Devices: devicesEntry.devices}
+		person := structs.Person{Name: devicesEntry.name}
+		if len(devicesEntry.devices) > 0 {
+			person.Devices = devicesEntry.devices
 			sort.Sort(structs.DevicesSorter(person.Devices))
-		} else {
-			person = structs.Person{Name: username}
 		}
 		peopleAndDevices.People = append(peopleAndDevices.People, person)
 	}
 	sort.Sort(structs.PersonSorter(peopleAndDevices.People))
 
-	success = true
 	return
 }
 
+// resolvePerson returns the key to group the devices of one person, the name of the person and the effective
+// visibility of the device at the given local time. The entries of the master db have no person, they are grouped by
+// name.
+func (d *DeviceData) resolvePerson(userInfo db.UserDbEntry, localNow time.Time) (string, string, db.Visibility) {
+	visibility := userInfo.Visibility
+	if scheduled, ok := userInfo.Schedule.VisibilityAt(localNow); ok {
+		visibility = scheduled
+	}
+
+	if userInfo.PersonId != "" {
+		if person, ok := d.userDb.GetPerson(userInfo.PersonId); ok {
+			return "id:" + person.Id, person.Name, db.EffectiveVisibility(visibility, person.DefaultVisibility)
+		}
+		ddLogger.WithField("personId", userInfo.PersonId).Warn("Unknown person.")
+	}
+	return "name:" + userInfo.Name, userInfo.Name, visibility
+}
+
+// localTimezone returns the configured timezone or the local one
+func (d *DeviceData) localTimezone() *time.Location {
+	if d.timezone == nil {
+		return time.Local
+	}
+	return d.timezone
+}
+
+func (d *DeviceData) resolveLocation(session structs.WifiSession) string {
+	if len(session.Location) > 0 {
+		return session.Location
+	}
+	// location attribute not set, resolve the location by the access point id
+	return d.findLocation(session.AP)
+}
+
 func (d *DeviceData) findLocation(apID int) string {
 
 	for _, location := range d.locations {
@@ -3,12 +3,16 @@ package mqtt
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/ktt-ol/spaceDevices/internal/conf"
 
 	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/internal/metrics"
 	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,18 +41,18 @@ func Test_parseWifiSessions(t *testing.T) {
 	assert.Equal(0, len(v.Ipv6))
 
 	v = findByIp(assert, sessions, "192.168.2.179")
-	assert.True(v.Mac == "10:68:3f:bb:bb:bb"  && v.AP == 1 && v.Location == "Radstelle")
+	assert.True(v.Mac == "10:68:3f:bb:bb:bb" && v.AP == 1 && v.Location == "Radstelle")
 	assert.Equal(2, len(v.Ipv6))
 	assert.Equal("6e7b:c7c6:9517:a9d0:958c:3939:c93e:9864", v.Ipv6[0])
 	assert.Equal("e759:68b6:4c7d:8483:81b7:be87:119b:7ee1", v.Ipv6[1])
 
 	v = findByIp(assert, sessions, "192.168.2.35")
-	assert.True(v.Mac == "20:c9:d0:cc:cc:cc"  && v.AP == 1)
+	assert.True(v.Mac == "20:c9:d0:cc:cc:cc" && v.AP == 1)
 	assert.Equal(1, len(v.Ipv6))
 	assert.Equal("325c:7fa7:cc79:bcb7:a2b1:26f6:a4ef:2501", v.Ipv6[0])
 
 	v = findByIp(assert, sessions, "10.18.159.6")
-	assert.True(v.Mac == "b8:53:ac:dd:dd:dd"  && v.AP == 1 && v.Location == "")
+	assert.True(v.Mac == "b8:53:ac:dd:dd:dd" && v.AP == 1 && v.Location == "")
 	assert.Equal(0, len(v.Ipv6))
 
 	// don't fail for garbage
@@ -57,6 +61,179 @@ func Test_parseWifiSessions(t *testing.T) {
 	assert.Equal(len(sessions), 0)
 }
 
+func Test_parseWifiSessions_invalidMac(t *testing.T) {
+	assert := assert.New(t)
+	dd := DeviceData{masterDb: &masterDbTest{}, userDb: &userDbTest{}}
+
+	sessions, _, ok := dd.parseWifiSessions([]byte(`[
+		{"ipv4": "10.1.1.1", "ipv6": [], "mac": "2C-0E-3D-AA-AA-AA", "ap": 2},
+		{"ipv4": "10.1.1.2", "ipv6": [], "mac": "2c:0e", "ap": 2}
+	]`))
+	assert.True(ok)
+	assert.Equal(1, len(sessions))
+	assert.Equal(structs.Mac("2c:0e:3d:aa:aa:aa"), sessions[0].Mac)
+}
+
+func Test_peopleCalculationByPerson(t *testing.T) {
+	assert := assert.New(t)
+	masterDb := &masterDbTest{masterMap: make(map[structs.Mac]db.MasterDbEntry)}
+	userMap := make(map[structs.Mac]db.UserDbEntry)
+	persons := map[string]db.Person{
+		"id1": {Id: "id1", Name: "Hans"},
+		"id2": {Id: "id2", Name: "Hans"},
+	}
+	userDb := &userDbTest{userMap: userMap, persons: persons}
+	dd := DeviceData{masterDb: masterDb, userDb: userDb}
+
+	testData := newSessionTestData(stt("1", "01"), stt("2", "02"), stt("3", "03"))
+	userMap["00:00:00:00:00:01"] = db.UserDbEntry{PersonId: "id1", DeviceName: "handy", Visibility: db.VisibilityAll}
+	userMap["00:00:00:00:00:02"] = db.UserDbEntry{PersonId: "id1", DeviceName: "laptop", Visibility: db.VisibilityAll}
+	// same name, but a different person
+	userMap["00:00:00:00:00:03"] = db.UserDbEntry{PersonId: "id2", DeviceName: "tablet", Visibility: db.VisibilityAll}
+	_, peopleAndDevices, _ := dd.parseWifiSessions(testData)
+	assertPeopleAndDevices(assert, 2, 2, 3, 0, peopleAndDevices)
+	deviceCounts := []int{len(peopleAndDevices.People[0].Devices), len(peopleAndDevices.People[1].Devices)}
+	sort.Ints(deviceCounts)
+	assert.Equal([]int{1, 2}, deviceCounts)
+
+	// a renamed person
+	persons["id1"] = db.Person{Id: "id1", Name: "Hansi"}
+	_, peopleAndDevices, _ = dd.parseWifiSessions(testData)
+	names := []string{peopleAndDevices.People[0].Name, peopleAndDevices.People[1].Name}
+	sort.Strings(names)
+	assert.Equal([]string{"Hans", "Hansi"}, names)
+}
+
+func Test_effectiveVisibility(t *testing.T) {
+	tests := []struct {
+		personDefault db.Visibility
+		device        db.Visibility
+		expected      db.Visibility
+	}{
+		{db.VisibilityAll, db.VisibilityDefault, db.VisibilityAll},
+		{db.VisibilityUser, db.VisibilityDefault, db.VisibilityUser},
+		{db.VisibilityAnon, db.VisibilityDefault, db.VisibilityAnon},
+		{db.VisibilityIgnore, db.VisibilityDefault, db.VisibilityIgnore},
+		{db.VisibilityAll, db.VisibilityIgnore, db.VisibilityIgnore},
+		{db.VisibilityIgnore, db.VisibilityAll, db.VisibilityAll},
+		{db.VisibilityUser, db.VisibilityAnon, db.VisibilityAnon},
+		{db.VisibilityAnon, db.VisibilityUser, db.VisibilityUser},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, db.EffectiveVisibility(test.device, test.personDefault),
+			"default %s, device %s", test.personDefault, test.device)
+	}
+}
+
+// the effective visibility of two present devices of the same person
+var twoDevicesVisibilityTests = []struct {
+	first       db.Visibility
+	second      db.Visibility
+	peopleCount uint16
+	named       bool
+	// the amount of devices in the device types, the ignored devices are missing
+	typed uint16
+}{
+	{db.VisibilityIgnore, db.VisibilityIgnore, 0, false, 0},
+	{db.VisibilityIgnore, db.VisibilityAnon, 1, false, 1},
+	{db.VisibilityIgnore, db.VisibilityUser, 1, true, 1},
+	{db.VisibilityIgnore, db.VisibilityAll, 1, true, 1},
+	{db.VisibilityAnon, db.VisibilityAnon, 1, false, 2},
+	{db.VisibilityAnon, db.VisibilityUser, 1, false, 2},
+	{db.VisibilityAnon, db.VisibilityAll, 1, false, 2},
+	{db.VisibilityUser, db.VisibilityUser, 1, true, 2},
+	{db.VisibilityUser, db.VisibilityAll, 1, true, 2},
+	{db.VisibilityAll, db.VisibilityAll, 1, true, 2},
+}
+
+func Test_personVisibility(t *testing.T) {
+	assert := assert.New(t)
+
+	visibilities := []db.Visibility{db.VisibilityIgnore, db.VisibilityAnon, db.VisibilityUser, db.VisibilityAll}
+	overrides := append([]db.Visibility{db.VisibilityDefault}, visibilities...)
+	expectedByEffective := func(first db.Visibility, second db.Visibility) (uint16, bool, uint16) {
+		for _, test := range twoDevicesVisibilityTests {
+			if (test.first == first && test.second == second) || (test.first == second && test.second == first) {
+				return test.peopleCount, test.named, test.typed
+			}
+		}
+		panic("missing test case for " + string(first) + ", " + string(second))
+	}
+
+	for _, personDefault := range visibilities {
+		for _, firstOverride := range overrides {
+			for _, secondOverride := range overrides {
+				userMap := map[structs.Mac]db.UserDbEntry{
+					"00:00:00:00:00:01": {PersonId: "id", DeviceName: "first", Visibility: firstOverride, DeviceType: "phone"},
+					"00:00:00:00:00:02": {PersonId: "id", DeviceName: "second", Visibility: secondOverride, DeviceType: "phone"},
+				}
+				persons := map[string]db.Person{"id": {Id: "id", Name: "hans", DefaultVisibility: personDefault}}
+				dd := DeviceData{masterDb: &masterDbTest{masterMap: map[structs.Mac]db.MasterDbEntry{}},
+					userDb: &userDbTest{userMap: userMap, persons: persons}}
+
+				first := db.EffectiveVisibility(firstOverride, personDefault)
+				second := db.EffectiveVisibility(secondOverride, personDefault)
+				peopleCount, named, typed := expectedByEffective(first, second)
+				var expectedDevices []string
+				if named && first == db.VisibilityAll {
+					expectedDevices = append(expectedDevices, "first")
+				}
+				if named && second == db.VisibilityAll {
+					expectedDevices = append(expectedDevices, "second")
+				}
+
+				msg := fmt.Sprintf("default %s, first %q, second %q", personDefault, firstOverride, secondOverride)
+				// the order of the sessions doesn't matter
+				for _, testData := range [][]byte{
+					newSessionTestData(stt("1", "01"), stt("2", "02")),
+					newSessionTestData(stt("2", "02"), stt("1", "01")),
+				} {
+					_, peopleAndDevices, _ := dd.parseWifiSessions(testData)
+					assert.Equal(peopleCount, peopleAndDevices.PeopleCount, msg)
+					assert.Equal(uint16(2), peopleAndDevices.DeviceCount, msg)
+					assert.Equal(typed, peopleAndDevices.DeviceTypes["phone"], msg)
+					if !named {
+						assert.Empty(peopleAndDevices.People, msg)
+						continue
+					}
+					if assert.Len(peopleAndDevices.People, 1, msg) {
+						assert.Equal("hans", peopleAndDevices.People[0].Name, msg)
+						var deviceNames []string
+						for _, device := range peopleAndDevices.People[0].Devices {
+							deviceNames = append(deviceNames, device.Name)
+						}
+						assert.Equal(expectedDevices, deviceNames, msg)
+					}
+				}
+			}
+		}
+	}
+}
+
+func Test_peopleCalculationWithSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	timezone := time.FixedZone("space", 2*60*60)
+	userMap := map[structs.Mac]db.UserDbEntry{
+		"00:00:00:00:00:01": {PersonId: "id", DeviceName: "handy", Schedule: db.VisibilitySchedule{
+			{Days: []string{"tue"}, From: "18:00", To: "23:00", Visibility: db.VisibilityAll},
+		}},
+	}
+	persons := map[string]db.Person{"id": {Id: "id", Name: "hans", DefaultVisibility: db.VisibilityAnon}}
+	dd := DeviceData{timezone: timezone, masterDb: &masterDbTest{masterMap: map[structs.Mac]db.MasterDbEntry{}},
+		userDb: &userDbTest{userMap: userMap, persons: persons}}
+	sessions := unmarshalWifiSessions(newSessionTestData(stt("1", "01")))
+
+	// 2019-03-05 is a tuesday, 16:00 UTC is 18:00 in the space
+	peopleAndDevices := dd.calculatePeopleAndDevices(time.Date(2019, 3, 5, 16, 0, 0, 0, time.UTC), sessions)
+	assertPeopleAndDevices(assert, 1, 1, 1, 0, peopleAndDevices)
+	assert.Equal("handy", peopleAndDevices.People[0].Devices[0].Name)
+
+	peopleAndDevices = dd.calculatePeopleAndDevices(time.Date(2019, 3, 5, 15, 59, 0, 0, time.UTC), sessions)
+	assertPeopleAndDevices(assert, 0, 1, 1, 0, peopleAndDevices)
+}
+
 func findByIp(assert *assert.Assertions, sessions []structs.WifiSession, ip string) *structs.WifiSession {
 	for _, v := range sessions {
 		if v.Ipv4 == ip {
@@ -70,10 +247,10 @@ func findByIp(assert *assert.Assertions, sessions []structs.WifiSession, ip stri
 
 func Test_peopleCalculation(t *testing.T) {
 	assert := assert.New(t)
-	masterMap := make(map[string]db.MasterDbEntry)
+	masterMap := make(map[structs.Mac]db.MasterDbEntry)
 	masterDb := &masterDbTest{masterMap: masterMap}
-	userMap := make(map[string]db.UserDbEntry)
-	userDb := &userDbTest{userMap}
+	userMap := make(map[structs.Mac]db.UserDbEntry)
+	userDb := &userDbTest{userMap: userMap}
 	locations := []conf.Location{conf.Location{Name: "Bar", Ids: []int{1, 3}}}
 	dd := DeviceData{locations: locations, masterDb: masterDb, userDb: userDb}
 
@@ -133,6 +310,48 @@ func assertPeopleAndDevices(assert *assert.Assertions, peopleArrayCount int, peo
 	assert.Equal(unknownDevicesCount, test.UnknownDevicesCount, "unknownDevicesCount")
 }
 
+func Test_updateMetrics(t *testing.T) {
+	assert := assert.New(t)
+	locations := []conf.Location{{Name: "Bar", Ids: []int{1}}, {Name: "Club", Ids: []int{4}}}
+	dd := DeviceData{locations: locations}
+
+	sessions := []structs.WifiSession{{Mac: "00:00:00:00:00:01", AP: 1}, {Mac: "00:00:00:00:00:02", AP: 1},
+		{Mac: "00:00:00:00:00:03", Location: "Radstelle"}, {Mac: "00:00:00:00:00:04", AP: 99}}
+	dd.updateMetrics(sessions, structs.PeopleAndDevices{PeopleCount: 1, DeviceCount: 4, UnknownDevicesCount: 2,
+		DeviceTypes: map[string]uint16{"printer": 2}})
+
+	assert.Equal(1.0, testutil.ToFloat64(metrics.PeopleCount))
+	assert.Equal(4.0, testutil.ToFloat64(metrics.DeviceCount))
+	assert.Equal(2.0, testutil.ToFloat64(metrics.UnknownDevicesCount))
+	assert.Equal(2.0, testutil.ToFloat64(metrics.DevicesPerLocation.WithLabelValues("Bar")))
+	assert.Equal(0.0, testutil.ToFloat64(metrics.DevicesPerLocation.WithLabelValues("Club")))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.DevicesPerLocation.WithLabelValues("Radstelle")))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.DevicesPerLocation.WithLabelValues(metrics.UnknownLocation)))
+	assert.Equal(2.0, testutil.ToFloat64(metrics.DevicesPerType.WithLabelValues("printer")))
+}
+
+func Test_peopleCalculationDeviceTypes(t *testing.T) {
+	assert := assert.New(t)
+	masterMap := map[structs.Mac]db.MasterDbEntry{
+		"00:00:00:00:00:01": {UserDbEntry: db.UserDbEntry{Name: "drucker", Visibility: db.VisibilityIgnore, DeviceType: "printer"}},
+		"00:00:00:00:00:02": {UserDbEntry: db.UserDbEntry{Name: "plotter", Visibility: db.VisibilityInfrastructure, DeviceType: "printer"}},
+		"00:00:00:00:00:03": {UserDbEntry: db.UserDbEntry{Name: "space", DeviceName: "beamer", Visibility: db.VisibilityAll, DeviceType: "beamer"}},
+		"00:00:00:00:00:04": {UserDbEntry: db.UserDbEntry{Name: "space", DeviceName: "tv", Visibility: db.VisibilityAll}},
+	}
+	dd := DeviceData{masterDb: &masterDbTest{masterMap: masterMap}, userDb: &userDbTest{userMap: map[structs.Mac]db.UserDbEntry{}}}
+
+	testData := newSessionTestData(stt("1", "01"), stt("2", "02"), stt("3", "03"), stt("4", "04"), stt("5", "05"))
+	_, peopleAndDevices, _ := dd.parseWifiSessions(testData)
+	// the ignored printer is missing
+	assert.Equal(map[string]uint16{"printer": 1, "beamer": 1}, peopleAndDevices.DeviceTypes)
+	assert.Equal([]structs.Devices{{Name: "beamer", Location: "Space", Type: "beamer"}, {Name: "tv", Location: "Space"}},
+		peopleAndDevices.People[0].Devices)
+
+	// no types, no map
+	_, peopleAndDevices, _ = dd.parseWifiSessions(newSessionTestData(stt("4", "04"), stt("5", "05")))
+	assert.Nil(peopleAndDevices.DeviceTypes)
+}
+
 func Test_peopleNeverNil(t *testing.T) {
 	assert := assert.New(t)
 	dd := DeviceData{}
@@ -148,41 +367,116 @@ func Test_peopleNeverNil(t *testing.T) {
 /****************************************/
 
 type sessionTestType struct {
-	Location string `Json:"location"`
-	Ipv4   string  `json:"ipv4"`
-	Ipv6 []string `json:"ipv6"`
-	Ap   float64 `json:"ap"`
-	Mac  string  `json:"mac"`
+	Location string   `Json:"location"`
+	Ipv4     string   `json:"ipv4"`
+	Ipv6     []string `json:"ipv6"`
+	Ap       float64  `json:"ap"`
+	Mac      string   `json:"mac"`
 }
 
 type userDbTest struct {
-	userMap map[string]db.UserDbEntry
+	userMap map[structs.Mac]db.UserDbEntry
+	persons map[string]db.Person
+}
+
+func (u *userDbTest) Get(mac structs.Mac) (db.UserDbEntry, bool) {
+	value, ok := u.userMap[mac]
+	return value, ok
+}
+
+func (u *userDbTest) Set(mac structs.Mac, info db.UserDbEntry) {
+	u.userMap[mac] = info
+}
+
+func (u *userDbTest) Delete(mac structs.Mac) {
+	delete(u.userMap, mac)
+}
+
+func (u *userDbTest) FindByPerson(personId string) map[structs.Mac]db.UserDbEntry {
+	result := make(map[structs.Mac]db.UserDbEntry)
+	for mac, entry := range u.userMap {
+		if entry.PersonId == personId {
+			result[mac] = entry
+		}
+	}
+	return result
+}
+
+func (u *userDbTest) FindByReclaimToken(token string) (structs.Mac, db.UserDbEntry, bool) {
+	for mac, entry := range u.userMap {
+		if token != "" && entry.ReclaimToken == token {
+			return mac, entry, true
+		}
+	}
+	return "", db.UserDbEntry{}, false
 }
 
-func (db *userDbTest) Get(mac string) (db.UserDbEntry, bool) {
-	value, ok := db.userMap[mac]
+func (u *userDbTest) GetPerson(id string) (db.Person, bool) {
+	value, ok := u.persons[id]
 	return value, ok
 }
 
-func (db *userDbTest) Set(mac string, info db.UserDbEntry) {
-	db.userMap[mac] = info
+func (u *userDbTest) NewPerson(name string, defaultVisibility db.Visibility) db.Person {
+	person := db.Person{Id: name + "-id", Name: name, DefaultVisibility: defaultVisibility}
+	u.persons[person.Id] = person
+	return person
 }
 
-func (db *userDbTest) Delete(mac string) {
-	delete(db.userMap, mac)
+func (u *userDbTest) SetPerson(person db.Person) {
+	u.persons[person.Id] = person
+}
+
+func (u *userDbTest) UpdateLastSeen(macs []structs.Mac, ts int64) {
+	for _, mac := range macs {
+		if entry, ok := u.userMap[mac]; ok {
+			entry.LastSeen = ts
+			u.userMap[mac] = entry
+		}
+	}
+}
+
+func (u *userDbTest) Expire(seenBefore int64, dryRun bool) []db.ExpiredEntry {
+	return nil
+}
+
+func (u *userDbTest) Count() int {
+	return len(u.userMap)
+}
+
+func (u *userDbTest) Close() {
 }
 
 type masterDbTest struct {
-	masterMap map[string]db.MasterDbEntry
+	masterMap map[structs.Mac]db.MasterDbEntry
 }
 
-func (db *masterDbTest) Get(mac string) (db.MasterDbEntry, bool) {
-	value, ok := db.masterMap[mac]
+func (m *masterDbTest) Get(mac structs.Mac) (db.MasterDbEntry, bool) {
+	value, ok := m.masterMap[mac]
 	return value, ok
 }
 
+func (m *masterDbTest) Count() int {
+	return len(m.masterMap)
+}
+
+func (m *masterDbTest) FindByVisibility(visibilities ...db.Visibility) map[structs.Mac]db.MasterDbEntry {
+	result := make(map[structs.Mac]db.MasterDbEntry)
+	for mac, entry := range m.masterMap {
+		for _, visibility := range visibilities {
+			if entry.Visibility == visibility {
+				result[mac] = entry
+			}
+		}
+	}
+	return result
+}
+
+func (m *masterDbTest) Reload() error {
+	return nil
+}
+
 func stt(lastIp string, lastMac string) sessionTestType {
-	return sessionTestType{"Space", "10.1.1." + lastIp, make([]string, 0, 0),1, "00:00:00:00:00:" + lastMac}
+	return sessionTestType{"Space", "10.1.1." + lastIp, make([]string, 0, 0), 1, "00:00:00:00:00:" + lastMac}
 }
 
 func newSessionTestData(testData ...sessionTestType) []byte {
@@ -0,0 +1,119 @@
+package mqtt
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/internal/metrics"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/sirupsen/logrus"
+)
+
+var iwLogger = logrus.WithField("where", "infrastructureWatcher")
+
+// the watched visibilities, only the critical devices are alerted
+var watchedVisibility = [...]db.Visibility{db.VisibilityCriticalInfrastructure, db.VisibilityImportantInfrastructure}
+
+type infrastructureState struct {
+	online bool
+	since  time.Time
+	alert  bool
+}
+
+// infrastructureWatcher tracks which critical and important infrastructure devices of the master db are online. It's
+// only used within the update loop of the DeviceData.
+type infrastructureWatcher struct {
+	// a value < 1 disables the alerts
+	alertAfter time.Duration
+	states     map[structs.Mac]*infrastructureState
+	// the devices of the last status, to send only the changes
+	lastDevices []structs.InfrastructureDevice
+}
+
+func newInfrastructureWatcher(alertAfter time.Duration) *infrastructureWatcher {
+	return &infrastructureWatcher{alertAfter: alertAfter, states: make(map[structs.Mac]*infrastructureState)}
+}
+
+// update evaluates the given sessions against the watched master db entries. It returns the current status, true if
+// the status changed since the last call and the alert events. A device that is offline from the start is missing
+// since the first update.
+func (w *infrastructureWatcher) update(now time.Time, entries map[structs.Mac]db.MasterDbEntry,
+	sessions []structs.WifiSession) (structs.InfrastructureStatus, bool, []structs.InfrastructureEvent) {
+	online := make(map[structs.Mac]bool, len(sessions))
+	for _, session := range sessions {
+		online[session.Mac] = true
+	}
+
+	var events []structs.InfrastructureEvent
+	devices := make([]structs.InfrastructureDevice, 0, len(entries))
+	for mac, entry := range entries {
+		name := masterEntryName(entry)
+		state, ok := w.states[mac]
+		if !ok {
+			state = &infrastructureState{online: online[mac], since: now}
+			w.states[mac] = state
+		}
+
+		if state.online != online[mac] {
+			state.online = online[mac]
+			if state.online && state.alert {
+				iwLogger.WithFields(logrus.Fields{"mac": mac, "name": name}).Info("Critical infrastructure is back.")
+				events = append(events, structs.InfrastructureEvent{Type: structs.InfrastructureBack, Mac: mac, Name: name,
+					MissingSince: toMs(state.since), Ts: toMs(now)})
+				state.alert = false
+			}
+			state.since = now
+		}
+
+		if !state.online && !state.alert && w.alertAfter > 0 && entry.Visibility == db.VisibilityCriticalInfrastructure &&
+			now.Sub(state.since) >= w.alertAfter {
+			iwLogger.WithFields(logrus.Fields{"mac": mac, "name": name, "since": state.since}).Warn("Critical infrastructure is missing.")
+			events = append(events, structs.InfrastructureEvent{Type: structs.InfrastructureMissing, Mac: mac, Name: name,
+				MissingSince: toMs(state.since), Ts: toMs(now)})
+			state.alert = true
+		}
+
+		devices = append(devices, structs.InfrastructureDevice{Mac: mac, Name: name, Visibility: string(entry.Visibility),
+			Online: state.online, Since: toMs(state.since), Alert: state.alert})
+	}
+	// removed from the master db
+	for mac := range w.states {
+		if _, ok := entries[mac]; !ok {
+			delete(w.states, mac)
+		}
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Name != devices[j].Name {
+			return devices[i].Name < devices[j].Name
+		}
+		return devices[i].Mac < devices[j].Mac
+	})
+	changed := w.lastDevices == nil || !reflect.DeepEqual(w.lastDevices, devices)
+	w.lastDevices = devices
+	updateInfrastructureMetrics(devices)
+
+	return structs.InfrastructureStatus{Ts: toMs(now), Devices: devices}, changed, events
+}
+
+func updateInfrastructureMetrics(devices []structs.InfrastructureDevice) {
+	offline := make(map[string]int)
+	for _, visibility := range watchedVisibility {
+		offline[string(visibility)] = 0
+	}
+	alerts := 0
+	for _, device := range devices {
+		if !device.Online {
+			offline[device.Visibility]++
+		}
+		if device.Alert {
+			alerts++
+		}
+	}
+	for visibility, count := range offline {
+		metrics.InfrastructureOffline.WithLabelValues(visibility).Set(float64(count))
+	}
+	metrics.InfrastructureAlerts.Set(float64(alerts))
+}
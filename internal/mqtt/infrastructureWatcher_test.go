@@ -0,0 +1,94 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_infrastructureWatcher(t *testing.T) {
+	a := assert.New(t)
+
+	entries := map[structs.Mac]db.MasterDbEntry{
+		"00:00:00:00:00:01": {UserDbEntry: db.UserDbEntry{Name: "router", Visibility: db.VisibilityCriticalInfrastructure}},
+		"00:00:00:00:00:02": {UserDbEntry: db.UserDbEntry{Name: "ap", Visibility: db.VisibilityImportantInfrastructure}},
+	}
+	router := structs.WifiSession{Mac: "00:00:00:00:00:01"}
+	ap := structs.WifiSession{Mac: "00:00:00:00:00:02"}
+
+	start := time.Unix(1000, 0)
+	w := newInfrastructureWatcher(10 * time.Minute)
+	status, changed, events := w.update(start, entries, []structs.WifiSession{router})
+	a.True(changed)
+	a.Len(events, 0)
+	a.Equal([]structs.InfrastructureDevice{
+		{Mac: "00:00:00:00:00:02", Name: "ap", Visibility: "important-infrastructure", Online: false, Since: toMs(start)},
+		{Mac: "00:00:00:00:00:01", Name: "router", Visibility: "critical-infrastructure", Online: true, Since: toMs(start)},
+	}, status.Devices)
+
+	// nothing changed
+	_, changed, _ = w.update(start.Add(time.Minute), entries, []structs.WifiSession{router})
+	a.False(changed)
+
+	// the router is gone, not alerted yet
+	gone := start.Add(2 * time.Minute)
+	status, changed, events = w.update(gone, entries, []structs.WifiSession{ap})
+	a.True(changed)
+	a.Len(events, 0)
+	a.False(status.Devices[1].Online)
+	a.Equal(toMs(gone), status.Devices[1].Since)
+
+	// alerted once
+	status, changed, events = w.update(gone.Add(10*time.Minute), entries, []structs.WifiSession{ap})
+	a.True(changed)
+	a.True(status.Devices[1].Alert)
+	a.Equal([]structs.InfrastructureEvent{{Type: structs.InfrastructureMissing, Mac: "00:00:00:00:00:01", Name: "router",
+		MissingSince: toMs(gone), Ts: toMs(gone.Add(10 * time.Minute))}}, events)
+	_, changed, events = w.update(gone.Add(20*time.Minute), entries, []structs.WifiSession{ap})
+	a.False(changed)
+	a.Len(events, 0)
+
+	// back again
+	back := gone.Add(30 * time.Minute)
+	status, changed, events = w.update(back, entries, []structs.WifiSession{ap, router})
+	a.True(changed)
+	a.False(status.Devices[1].Alert)
+	a.Equal([]structs.InfrastructureEvent{{Type: structs.InfrastructureBack, Mac: "00:00:00:00:00:01", Name: "router",
+		MissingSince: toMs(gone), Ts: toMs(back)}}, events)
+}
+
+func Test_infrastructureWatcher_onlyCritical(t *testing.T) {
+	a := assert.New(t)
+
+	entries := map[structs.Mac]db.MasterDbEntry{
+		"00:00:00:00:00:02": {UserDbEntry: db.UserDbEntry{Name: "ap", Visibility: db.VisibilityImportantInfrastructure}},
+	}
+	start := time.Unix(1000, 0)
+	w := newInfrastructureWatcher(time.Minute)
+	w.update(start, entries, nil)
+	status, _, events := w.update(start.Add(time.Hour), entries, nil)
+	a.Len(events, 0)
+	a.False(status.Devices[0].Alert)
+
+	// removed from the master db
+	status, changed, _ := w.update(start.Add(time.Hour), map[structs.Mac]db.MasterDbEntry{}, nil)
+	a.True(changed)
+	a.Len(status.Devices, 0)
+	a.Len(w.states, 0)
+}
+
+func Test_infrastructureWatcher_alertsDisabled(t *testing.T) {
+	a := assert.New(t)
+
+	entries := map[structs.Mac]db.MasterDbEntry{
+		"00:00:00:00:00:01": {UserDbEntry: db.UserDbEntry{Name: "router", Visibility: db.VisibilityCriticalInfrastructure}},
+	}
+	start := time.Unix(1000, 0)
+	w := newInfrastructureWatcher(0)
+	w.update(start, entries, nil)
+	_, _, events := w.update(start.Add(24*time.Hour), entries, nil)
+	a.Len(events, 0)
+}
@@ -5,10 +5,15 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang"
 	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/internal/metrics"
 	"github.com/ktt-ol/spaceDevices/pkg/structs"
 	log "github.com/sirupsen/logrus"
 )
@@ -17,12 +22,42 @@ const CLIENT_ID = "spaceDevicesGo"
 
 var mqttLogger = log.WithField("where", "mqtt")
 
+// mqttStats are updated from different go routines, thus only use atomic operations. The 64 bit values must be the
+// first fields for the alignment on 32 bit systems.
+type mqttStats struct {
+	connects       uint64
+	parseErrors    uint64
+	lastSessionsTs int64
+	lastPublishTs  int64
+	connected      int32
+	// 1, if the watch dog published the stale devices
+	stale int32
+}
+
 type MqttHandler struct {
+	stats        mqttStats
 	client       mqtt.Client
 	newDataChan  chan []byte
 	sessionTopic string
 	devicesTopic string
+	eventsTopic  string
+	statusTopic  string
+	heartbeat    time.Duration
+	clientOnly   bool
 	watchDog     *watchDog
+
+	// empty, if no device type summary is sent
+	deviceTypesTopic string
+	// empty, if the infrastructure isn't watched
+	infrastructureTopic string
+	infrastructureAlert time.Duration
+	// empty, if the space state isn't used
+	spaceStateTopic   string
+	spaceClosedStates []string
+	// true, if the space is closed. Only the newest state is kept.
+	spaceStates          chan bool
+	poweredWarningTopic  string
+	poweredWarningRepeat time.Duration
 }
 
 //func init() {
@@ -70,10 +105,19 @@ func NewMqttHandler(conf conf.MqttConf, clientOnly bool) *MqttHandler {
 		opts.SetAutoReconnect(true)
 		opts.SetKeepAlive(10 * time.Second)
 		opts.SetMaxReconnectInterval(5 * time.Minute)
+		// a dead service must not leave the last people behind, the status shows it by its outdated ts
 		opts.SetWill(conf.DevicesTopic, emptyPeopleAndDevices(), 0, true)
 	}
 
-	handler := MqttHandler{newDataChan: make(chan []byte), devicesTopic: conf.DevicesTopic, sessionTopic: conf.SessionTopic}
+	handler := MqttHandler{newDataChan: make(chan []byte), devicesTopic: conf.DevicesTopic, sessionTopic: conf.SessionTopic,
+		eventsTopic: conf.EventsTopic, statusTopic: conf.StatusTopic, heartbeat: time.Duration(conf.HeartbeatInMinutes) * time.Minute,
+		infrastructureTopic: conf.InfrastructureTopic, infrastructureAlert: time.Duration(conf.InfrastructureAlertInMinutes) * time.Minute,
+		spaceStateTopic: conf.SpaceStateTopic, spaceClosedStates: conf.SpaceClosedStates, spaceStates: make(chan bool, 1),
+		poweredWarningTopic: conf.PoweredWarningTopic, poweredWarningRepeat: time.Duration(conf.PoweredWarningRepeatInMinutes) * time.Minute,
+		deviceTypesTopic: conf.DeviceTypesTopic, clientOnly: clientOnly}
+	if len(handler.spaceClosedStates) == 0 {
+		handler.spaceClosedStates = []string{"closed"}
+	}
 	opts.SetOnConnectHandler(handler.onConnect)
 	if !clientOnly {
 		opts.SetConnectionLostHandler(handler.onConnectionLost)
@@ -84,23 +128,92 @@ func NewMqttHandler(conf conf.MqttConf, clientOnly bool) *MqttHandler {
 		mqttLogger.WithError(tok.Error()).Fatal("Could not connect to mqtt server.")
 	}
 
-	if !clientOnly && conf.WatchDogTimeoutInMinutes > 0 {
-		mqttLogger.Println("Enable mqtt watch dog, timeout in minutes is", conf.WatchDogTimeoutInMinutes)
-		handler.watchDog = NewWatchDog(time.Duration(conf.WatchDogTimeoutInMinutes) * time.Minute)
+	if !clientOnly && (conf.WatchDogTimeoutInMinutes > 0 || conf.WatchDogExitInMinutes > 0) {
+		mqttLogger.Println("Enable mqtt watch dog, timeout in minutes is", conf.WatchDogTimeoutInMinutes,
+			"exit in minutes is", conf.WatchDogExitInMinutes)
+		handler.watchDog = NewWatchDog(handler.recoveryStages(
+			time.Duration(conf.WatchDogTimeoutInMinutes)*time.Minute, time.Duration(conf.WatchDogExitInMinutes)*time.Minute))
 	}
 
 	return &handler
 }
 
+// recoveryStages returns the watch dog actions, a timeout < 1 disables the stages. The exit is delayed until the stale
+// devices had a chance to be published.
+func (h *MqttHandler) recoveryStages(timeout time.Duration, exitTimeout time.Duration) []recoveryStage {
+	if minExit := 3*timeout + watchDogCheckInterval; timeout > 0 && exitTimeout > 0 && exitTimeout < minExit {
+		mqttLogger.WithFields(log.Fields{"exitTimeout": exitTimeout, "minExitTimeout": minExit}).
+			Warn("The watch dog exit is before the stale devices are published, using the minimum.")
+		exitTimeout = minExit
+	}
+
+	var stages []recoveryStage
+	if timeout > 0 {
+		stages = append(stages,
+			recoveryStage{name: "resubscribe", after: timeout, action: h.resubscribe},
+			recoveryStage{name: "reconnect", after: 2 * timeout, action: h.reconnect},
+			recoveryStage{name: "publishStale", after: 3 * timeout, action: h.publishStale},
+		)
+	}
+	if exitTimeout > 0 {
+		stages = append(stages, recoveryStage{name: "exit", after: exitTimeout, action: func() {
+			mqttLogger.Error("No sessions for too long, exiting.")
+			os.Exit(3)
+		}})
+	}
+	sort.SliceStable(stages, func(i, j int) bool {
+		return stages[i].after < stages[j].after
+	})
+
+	return stages
+}
+
+func (h *MqttHandler) resubscribe() {
+	if tok := h.client.Unsubscribe(h.sessionTopic); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		mqttLogger.WithError(tok.Error()).WithField("topic", h.sessionTopic).Warn("Could not unsubscribe.")
+	}
+	if err := subscribe(h.client, h.sessionTopic, h.onSessions); err != nil {
+		mqttLogger.WithError(err).WithField("topic", h.sessionTopic).Warn("Could not resubscribe.")
+	}
+}
+
+func (h *MqttHandler) reconnect() {
+	h.client.Disconnect(250)
+	atomic.StoreInt32(&h.stats.connected, 0)
+	// onConnect subscribes again
+	if tok := h.client.Connect(); !tok.WaitTimeout(10*time.Second) || tok.Error() != nil {
+		mqttLogger.WithError(tok.Error()).Warn("Could not reconnect.")
+	}
+}
+
+// publishStale replaces the retained devices with an empty list, marked as stale.
+func (h *MqttHandler) publishStale() {
+	if h.SendPeopleAndDevices(structs.PeopleAndDevices{People: []structs.Person{}, Stale: true}) {
+		atomic.StoreInt32(&h.stats.stale, 1)
+	}
+}
+
+// takeStale returns true, if the stale devices were published since the last call. The next devices must be sent, even
+// if they didn't change.
+func (h *MqttHandler) takeStale() bool {
+	return atomic.SwapInt32(&h.stats.stale, 0) == 1
+}
+
+// isStale returns true, if the stale devices are published and no sessions arrived since then.
+func (h *MqttHandler) isStale() bool {
+	return atomic.LoadInt32(&h.stats.stale) == 1
+}
+
 func (h *MqttHandler) GetNewDataChannel() chan []byte {
 	return h.newDataChan
 }
 
-func (h *MqttHandler) SendPeopleAndDevices(data structs.PeopleAndDevices) {
+// SendPeopleAndDevices publishes the data (retained) to the devices topic and returns true on success.
+func (h *MqttHandler) SendPeopleAndDevices(data structs.PeopleAndDevices) bool {
 	bytes, err := json.Marshal(data)
 	if err != nil {
 		mqttLogger.Errorln("Invalid people json", err)
-		return
+		return false
 	}
 
 	mqttLogger.Infof("Sending PeopleAndDevices: %d, %d, %d, %d",
@@ -108,72 +221,261 @@ func (h *MqttHandler) SendPeopleAndDevices(data structs.PeopleAndDevices) {
 
 	token := h.client.Publish(h.devicesTopic, 0, true, string(bytes))
 	ok := token.WaitTimeout(time.Duration(time.Second * 10))
-	if !ok {
+	if !ok || token.Error() != nil {
 		mqttLogger.WithError(token.Error()).WithField("topic", h.devicesTopic).Warn("Error sending devices.")
+		metrics.MqttPublishes.WithLabelValues("failure").Inc()
+		return false
+	}
+	metrics.MqttPublishes.WithLabelValues("success").Inc()
+	atomic.StoreInt64(&h.stats.lastPublishTs, toMs(time.Now()))
+	return true
+}
+
+// countParseError must be called for every invalid session payload
+func (h *MqttHandler) countParseError() {
+	atomic.AddUint64(&h.stats.parseErrors, 1)
+}
+
+// status returns the current state of the mqtt connection, without the db entries
+func (h *MqttHandler) status() structs.ServiceStatus {
+	reconnects := atomic.LoadUint64(&h.stats.connects)
+	if reconnects > 0 {
+		// the first one is not a reconnect
+		reconnects--
+	}
+	return structs.ServiceStatus{
+		State:          structs.StateOnline,
+		Ts:             toMs(time.Now()),
+		MqttConnected:  atomic.LoadInt32(&h.stats.connected) == 1,
+		MqttReconnects: reconnects,
+		LastSessionsTs: atomic.LoadInt64(&h.stats.lastSessionsTs),
+		LastPublishTs:  atomic.LoadInt64(&h.stats.lastPublishTs),
+		ParseErrors:    atomic.LoadUint64(&h.stats.parseErrors),
+	}
+}
+
+// SendPresenceEvents publishes every event as a single message to the events topic, if configured.
+func (h *MqttHandler) SendPresenceEvents(events []structs.PresenceEvent) {
+	for _, event := range events {
+		if !h.sendEvent(event) {
+			return
+		}
+	}
+}
+
+// SendInfrastructureEvents publishes the alerts like the presence events to the events topic, if configured.
+func (h *MqttHandler) SendInfrastructureEvents(events []structs.InfrastructureEvent) {
+	for _, event := range events {
+		if !h.sendEvent(event) {
+			return
+		}
+	}
+}
+
+// sendEvent publishes a single event and returns false, if the events topic is unreachable.
+func (h *MqttHandler) sendEvent(event interface{}) bool {
+	if h.eventsTopic == "" {
+		return true
+	}
+
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		mqttLogger.Errorln("Invalid event json", err)
+		return true
+	}
+
+	mqttLogger.WithField("event", string(bytes)).Debug("Sending event.")
+	token := h.client.Publish(h.eventsTopic, 0, false, string(bytes))
+	ok := token.WaitTimeout(time.Duration(time.Second * 10))
+	if !ok {
+		mqttLogger.WithError(token.Error()).WithField("topic", h.eventsTopic).Warn("Error sending event.")
+		return false
+	}
+	return true
+}
+
+// SendDeviceTypes publishes the summary (retained) to the device types topic and returns true on success.
+func (h *MqttHandler) SendDeviceTypes(summary structs.DeviceTypeSummary) bool {
+	bytes, err := json.Marshal(summary)
+	if err != nil {
+		mqttLogger.Errorln("Invalid device types json", err)
+		return false
+	}
+
+	token := h.client.Publish(h.deviceTypesTopic, 0, true, string(bytes))
+	if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		mqttLogger.WithError(token.Error()).WithField("topic", h.deviceTypesTopic).Warn("Error sending device types.")
+		return false
+	}
+	return true
+}
+
+// SendInfrastructureStatus publishes the status (retained) to the infrastructure topic.
+func (h *MqttHandler) SendInfrastructureStatus(status structs.InfrastructureStatus) {
+	bytes, err := json.Marshal(status)
+	if err != nil {
+		mqttLogger.Errorln("Invalid infrastructure json", err)
 		return
 	}
+
+	token := h.client.Publish(h.infrastructureTopic, 0, true, string(bytes))
+	if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		mqttLogger.WithError(token.Error()).WithField("topic", h.infrastructureTopic).Warn("Error sending infrastructure status.")
+	}
+}
+
+// Shutdown stops receiving sessions and the watch dog, publishes the empty devices and the offline state and
+// disconnects from the server.
+func (h *MqttHandler) Shutdown() {
+	mqttLogger.Info("Shutting down.")
+
+	if tok := h.client.Unsubscribe(h.sessionTopic); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		mqttLogger.WithError(tok.Error()).WithField("topic", h.sessionTopic).Warn("Could not unsubscribe.")
+	}
+	if h.watchDog != nil {
+		h.watchDog.Stop()
+	}
+
+	h.SendPeopleAndDevices(structs.PeopleAndDevices{People: []structs.Person{}})
+	status := h.status()
+	status.State = structs.StateOffline
+	h.sendStatus(status)
+
+	// wait up to 1 sec for the outstanding work
+	h.client.Disconnect(1000)
+}
+
+func (h *MqttHandler) sendStatus(status structs.ServiceStatus) {
+	if h.statusTopic == "" {
+		return
+	}
+
+	bytes, err := json.Marshal(status)
+	if err != nil {
+		mqttLogger.Errorln("Invalid status json", err)
+		return
+	}
+
+	token := h.client.Publish(h.statusTopic, 0, true, string(bytes))
+	if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		mqttLogger.WithError(token.Error()).WithField("topic", h.statusTopic).Warn("Error sending status.")
+	}
 }
 
 func (h *MqttHandler) onConnect(client mqtt.Client) {
 	mqttLogger.Info("connected")
+	atomic.AddUint64(&h.stats.connects, 1)
+	atomic.StoreInt32(&h.stats.connected, 1)
+	if !h.clientOnly {
+		// don't block the paho callback
+		go h.sendStatus(h.status())
+	}
 
-	err := subscribe(client, h.sessionTopic,
-		func(client mqtt.Client, message mqtt.Message) {
-			mqttLogger.Debug("new wifi sessions")
-			if h.watchDog != nil {
-				h.watchDog.Ping()
-			}
-
-			/*
-			[{"ipv4": "192.99.99.99", "ipv6": "", "mac": "18:fe:ab:ab:ab:ab", "ap": 105, "location": "Space"} ]
-			 */
-
-			/*
-							mock := []byte(`{  "38134": {
-				    "last-auth": 1509211121,
-				    "vlan": "default",
-				    "stats": {
-				      "rx-multicast-pkts": 0,
-				      "rx-unicast-pkts": 292,
-				      "tx-unicast-pkts": 654,
-				      "rx-unicast-bytes": 20510,
-				      "tx-unicast-bytes": 278565,
-				      "rx-multicast-bytes": 0
-				    },
-				    "ssid": "mainframe",
-				    "ip": "::1",
-				    "hostname": "-",
-				    "last-snr": 47,
-				    "last-rate-mbits": "6",
-				    "ap": 1,
-				    "mac": "d4:38:9c:01:dd:03",
-				    "radio": 2,
-				    "userinfo": {
-				      "name": "Holger",
-				      "visibility": "show",
-				      "ts": 1427737817755
-				    },
-				    "session-start": 1509211121,
-				    "last-rssi-dbm": -48,
-				    "last-activity": 1509211584
-				  }}`)
-			*/
-			select {
-			//case h.newDataChan <- mock:
-			case h.newDataChan <- message.Payload():
-				break
-			default:
-				mqttLogger.Println("No one receives the message.")
-			}
-
-		})
+	err := subscribe(client, h.sessionTopic, h.onSessions)
 	if err != nil {
 		mqttLogger.WithField("topic", h.sessionTopic).WithError(err).Fatal("Could not subscribe.")
 	}
+	if !h.clientOnly && h.spaceStateTopic != "" {
+		if err := subscribe(client, h.spaceStateTopic, h.onSpaceState); err != nil {
+			mqttLogger.WithField("topic", h.spaceStateTopic).WithError(err).Error("Could not subscribe.")
+		}
+	}
+}
+
+func (h *MqttHandler) onSpaceState(client mqtt.Client, message mqtt.Message) {
+	closed := h.isClosedState(string(message.Payload()))
+	mqttLogger.WithField("closed", closed).Debug("new space state")
+	select {
+	case h.spaceStates <- closed:
+	default:
+		// replace the pending state, this is the only sender
+		select {
+		case <-h.spaceStates:
+		default:
+		}
+		h.spaceStates <- closed
+	}
+}
+
+// isClosedState returns true, if the payload of the space state topic is one of the closed states
+func (h *MqttHandler) isClosedState(payload string) bool {
+	payload = strings.TrimSpace(payload)
+	for _, state := range h.spaceClosedStates {
+		if strings.EqualFold(payload, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendPoweredWarning publishes the warning (not retained) to the powered warning topic.
+func (h *MqttHandler) SendPoweredWarning(warning structs.PoweredWarning) {
+	bytes, err := json.Marshal(warning)
+	if err != nil {
+		mqttLogger.Errorln("Invalid warning json", err)
+		return
+	}
+
+	token := h.client.Publish(h.poweredWarningTopic, 0, false, string(bytes))
+	if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		mqttLogger.WithError(token.Error()).WithField("topic", h.poweredWarningTopic).Warn("Error sending powered warning.")
+	}
+}
+
+func (h *MqttHandler) onSessions(client mqtt.Client, message mqtt.Message) {
+	mqttLogger.Debug("new wifi sessions")
+	atomic.StoreInt64(&h.stats.lastSessionsTs, toMs(time.Now()))
+	metrics.SessionMessages.Inc()
+	if h.watchDog != nil {
+		h.watchDog.Ping()
+	}
+
+	/*
+	[{"ipv4": "192.99.99.99", "ipv6": "", "mac": "18:fe:ab:ab:ab:ab", "ap": 105, "location": "Space"} ]
+	 */
+
+	/*
+					mock := []byte(`{  "38134": {
+		    "last-auth": 1509211121,
+		    "vlan": "default",
+		    "stats": {
+		      "rx-multicast-pkts": 0,
+		      "rx-unicast-pkts": 292,
+		      "tx-unicast-pkts": 654,
+		      "rx-unicast-bytes": 20510,
+		      "tx-unicast-bytes": 278565,
+		      "rx-multicast-bytes": 0
+		    },
+		    "ssid": "mainframe",
+		    "ip": "::1",
+		    "hostname": "-",
+		    "last-snr": 47,
+		    "last-rate-mbits": "6",
+		    "ap": 1,
+		    "mac": "d4:38:9c:01:dd:03",
+		    "radio": 2,
+		    "userinfo": {
+		      "name": "Holger",
+		      "visibility": "show",
+		      "ts": 1427737817755
+		    },
+		    "session-start": 1509211121,
+		    "last-rssi-dbm": -48,
+		    "last-activity": 1509211584
+		  }}`)
+	*/
+	select {
+	//case h.newDataChan <- mock:
+	case h.newDataChan <- message.Payload():
+		break
+	default:
+		mqttLogger.Println("No one receives the message.")
+	}
 }
 
 func (h *MqttHandler) onConnectionLost(client mqtt.Client, err error) {
 	mqttLogger.WithError(err).Error("Connection lost.")
+	atomic.StoreInt32(&h.stats.connected, 0)
 }
 
 func subscribe(client mqtt.Client, topic string, cb mqtt.MessageHandler) error {
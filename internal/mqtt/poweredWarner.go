@@ -0,0 +1,60 @@
+package mqtt
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+)
+
+// poweredWarner decides when to warn about the devices with the powered-while-closed-warning, that are online while
+// the space is closed. It's only used within the update loop of the DeviceData.
+type poweredWarner struct {
+	// a value < 1 warns only once per closing
+	repeat      time.Duration
+	closed      bool
+	closedSince time.Time
+	// zero, if there was no warning since the space closed or the devices went offline
+	lastWarning time.Time
+}
+
+func newPoweredWarner(repeat time.Duration) *poweredWarner {
+	return &poweredWarner{repeat: repeat}
+}
+
+// setClosed must be called for every space state
+func (w *poweredWarner) setClosed(now time.Time, closed bool) {
+	if closed && !w.closed {
+		w.closedSince = now
+	}
+	if !closed {
+		w.lastWarning = time.Time{}
+	}
+	w.closed = closed
+}
+
+// check returns the warning and true, if the given online devices must be reported now
+func (w *poweredWarner) check(now time.Time, devices []structs.Devices) (structs.PoweredWarning, bool) {
+	if !w.closed || len(devices) == 0 {
+		w.lastWarning = time.Time{}
+		return structs.PoweredWarning{}, false
+	}
+	if !w.lastWarning.IsZero() && (w.repeat < 1 || now.Sub(w.lastWarning) < w.repeat) {
+		return structs.PoweredWarning{}, false
+	}
+
+	w.lastWarning = now
+	sorted := make([]structs.Devices, len(devices))
+	copy(sorted, devices)
+	sort.Sort(structs.DevicesSorter(sorted))
+	return structs.PoweredWarning{Devices: sorted, ClosedSince: toMs(w.closedSince), Ts: toMs(now)}, true
+}
+
+// masterEntryName returns the name of the entry, the device name if it has none
+func masterEntryName(entry db.MasterDbEntry) string {
+	if entry.Name != "" {
+		return entry.Name
+	}
+	return entry.DeviceName
+}
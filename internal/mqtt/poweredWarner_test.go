@@ -0,0 +1,78 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_poweredWarner(t *testing.T) {
+	a := assert.New(t)
+
+	devices := []structs.Devices{{Name: "Lötstation", Location: "Werkstatt"}, {Name: "3D-Drucker", Location: "Space"}}
+	start := time.Unix(1000, 0)
+	w := newPoweredWarner(10 * time.Minute)
+
+	// open
+	_, ok := w.check(start, devices)
+	a.False(ok)
+
+	closed := start.Add(time.Minute)
+	w.setClosed(closed, true)
+	warning, ok := w.check(closed, devices)
+	a.True(ok)
+	a.Equal(structs.PoweredWarning{Devices: []structs.Devices{{Name: "3D-Drucker", Location: "Space"},
+		{Name: "Lötstation", Location: "Werkstatt"}}, ClosedSince: toMs(closed), Ts: toMs(closed)}, warning)
+
+	// repeated after the interval
+	_, ok = w.check(closed.Add(5*time.Minute), devices)
+	a.False(ok)
+	// the same state again doesn't change the closed time
+	w.setClosed(closed.Add(6*time.Minute), true)
+	warning, ok = w.check(closed.Add(10*time.Minute), devices)
+	a.True(ok)
+	a.Equal(toMs(closed), warning.ClosedSince)
+
+	// offline, the next device is reported immediately
+	_, ok = w.check(closed.Add(11*time.Minute), nil)
+	a.False(ok)
+	_, ok = w.check(closed.Add(12*time.Minute), devices[:1])
+	a.True(ok)
+
+	// opened
+	w.setClosed(closed.Add(13*time.Minute), false)
+	_, ok = w.check(closed.Add(30*time.Minute), devices)
+	a.False(ok)
+}
+
+func Test_poweredWarner_once(t *testing.T) {
+	a := assert.New(t)
+
+	devices := []structs.Devices{{Name: "Lötstation"}}
+	start := time.Unix(1000, 0)
+	w := newPoweredWarner(0)
+	w.setClosed(start, true)
+	_, ok := w.check(start, devices)
+	a.True(ok)
+	_, ok = w.check(start.Add(24*time.Hour), devices)
+	a.False(ok)
+
+	// the next closing warns again
+	w.setClosed(start.Add(25*time.Hour), false)
+	w.setClosed(start.Add(26*time.Hour), true)
+	warning, ok := w.check(start.Add(26*time.Hour), devices)
+	a.True(ok)
+	a.Equal(toMs(start.Add(26*time.Hour)), warning.ClosedSince)
+}
+
+func Test_isClosedState(t *testing.T) {
+	a := assert.New(t)
+
+	h := MqttHandler{spaceClosedStates: []string{"closed", "none"}}
+	a.True(h.isClosedState("closed"))
+	a.True(h.isClosedState(" None\n"))
+	a.False(h.isClosedState("open"))
+	a.False(h.isClosedState(""))
+}
@@ -0,0 +1,85 @@
+package mqtt
+
+import (
+	"sort"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+)
+
+type deviceKey struct {
+	person   string
+	device   string
+	location string
+}
+
+// diffPeopleAndDevices returns the arrive/leave events between the two given states. Only the people and devices of
+// the published data are considered, thus the same visibility rules apply. A device that changes its location
+// leaves the old and arrives at the new location.
+func diffPeopleAndDevices(before structs.PeopleAndDevices, after structs.PeopleAndDevices, ts int64) []structs.PresenceEvent {
+	beforePeople, beforeDevices := indexPeopleAndDevices(before)
+	afterPeople, afterDevices := indexPeopleAndDevices(after)
+
+	events := make([]structs.PresenceEvent, 0)
+	for _, name := range sortedNames(afterPeople) {
+		if !beforePeople[name] {
+			events = append(events, structs.PresenceEvent{Type: structs.PersonArrived, Person: name, Ts: ts})
+		}
+	}
+	for _, key := range sortedDeviceKeys(afterDevices) {
+		for i := beforeDevices[key]; i < afterDevices[key]; i++ {
+			events = append(events, structs.PresenceEvent{Type: structs.DeviceArrived, Person: key.person,
+				Device: key.device, Location: key.location, Ts: ts})
+		}
+	}
+	for _, key := range sortedDeviceKeys(beforeDevices) {
+		for i := afterDevices[key]; i < beforeDevices[key]; i++ {
+			events = append(events, structs.PresenceEvent{Type: structs.DeviceLeft, Person: key.person,
+				Device: key.device, Location: key.location, Ts: ts})
+		}
+	}
+	for _, name := range sortedNames(beforePeople) {
+		if !afterPeople[name] {
+			events = append(events, structs.PresenceEvent{Type: structs.PersonLeft, Person: name, Ts: ts})
+		}
+	}
+
+	return events
+}
+
+func indexPeopleAndDevices(data structs.PeopleAndDevices) (map[string]bool, map[deviceKey]int) {
+	people := make(map[string]bool)
+	devices := make(map[deviceKey]int)
+	for _, person := range data.People {
+		people[person.Name] = true
+		for _, device := range person.Devices {
+			devices[deviceKey{person: person.Name, device: device.Name, location: device.Location}]++
+		}
+	}
+	return people, devices
+}
+
+func sortedNames(m map[string]bool) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedDeviceKeys(m map[deviceKey]int) []deviceKey {
+	keys := make([]deviceKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].person != keys[j].person {
+			return keys[i].person < keys[j].person
+		}
+		if keys[i].device != keys[j].device {
+			return keys[i].device < keys[j].device
+		}
+		return keys[i].location < keys[j].location
+	})
+	return keys
+}
@@ -0,0 +1,61 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_diffPeopleAndDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	empty := structs.PeopleAndDevices{People: []structs.Person{}}
+	assert.Equal(0, len(diffPeopleAndDevices(empty, empty, 1)))
+
+	first := structs.PeopleAndDevices{People: []structs.Person{
+		{Name: "hans"},
+		{Name: "olaf", Devices: []structs.Devices{{Name: "iphone", Location: "Bar"}}},
+	}}
+	assert.Equal([]structs.PresenceEvent{
+		{Type: structs.PersonArrived, Person: "hans", Ts: 1},
+		{Type: structs.PersonArrived, Person: "olaf", Ts: 1},
+		{Type: structs.DeviceArrived, Person: "olaf", Device: "iphone", Location: "Bar", Ts: 1},
+	}, diffPeopleAndDevices(empty, first, 1))
+
+	// nothing changed
+	assert.Equal(0, len(diffPeopleAndDevices(first, first, 2)))
+
+	second := structs.PeopleAndDevices{People: []structs.Person{
+		{Name: "olaf", Devices: []structs.Devices{{Name: "iphone", Location: "Club"}, {Name: "mac", Location: "Bar"}}},
+	}}
+	assert.Equal([]structs.PresenceEvent{
+		{Type: structs.DeviceArrived, Person: "olaf", Device: "iphone", Location: "Club", Ts: 3},
+		{Type: structs.DeviceArrived, Person: "olaf", Device: "mac", Location: "Bar", Ts: 3},
+		{Type: structs.DeviceLeft, Person: "olaf", Device: "iphone", Location: "Bar", Ts: 3},
+		{Type: structs.PersonLeft, Person: "hans", Ts: 3},
+	}, diffPeopleAndDevices(first, second, 3))
+
+	assert.Equal([]structs.PresenceEvent{
+		{Type: structs.DeviceLeft, Person: "olaf", Device: "iphone", Location: "Club", Ts: 4},
+		{Type: structs.DeviceLeft, Person: "olaf", Device: "mac", Location: "Bar", Ts: 4},
+		{Type: structs.PersonLeft, Person: "olaf", Ts: 4},
+	}, diffPeopleAndDevices(second, empty, 4))
+}
+
+func Test_diffPeopleAndDevices_sameDeviceName(t *testing.T) {
+	assert := assert.New(t)
+
+	one := structs.PeopleAndDevices{People: []structs.Person{
+		{Name: "olaf", Devices: []structs.Devices{{Name: "", Location: "Bar"}}},
+	}}
+	two := structs.PeopleAndDevices{People: []structs.Person{
+		{Name: "olaf", Devices: []structs.Devices{{Name: "", Location: "Bar"}, {Name: "", Location: "Bar"}}},
+	}}
+	assert.Equal([]structs.PresenceEvent{
+		{Type: structs.DeviceArrived, Person: "olaf", Location: "Bar", Ts: 1},
+	}, diffPeopleAndDevices(one, two, 1))
+	assert.Equal([]structs.PresenceEvent{
+		{Type: structs.DeviceLeft, Person: "olaf", Location: "Bar", Ts: 2},
+	}, diffPeopleAndDevices(two, one, 2))
+}
@@ -0,0 +1,84 @@
+package mqtt
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+)
+
+type trackedSession struct {
+	session   structs.WifiSession
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// sessionDebouncer smooths the session list: a new mac counts only after the arrival delay and a disappeared mac
+// still counts until the grace period is over.
+type sessionDebouncer struct {
+	arrivalDelay time.Duration
+	gracePeriod  time.Duration
+	sessions     map[structs.Mac]*trackedSession
+}
+
+func newSessionDebouncer(config conf.DebounceConf) *sessionDebouncer {
+	sd := sessionDebouncer{sessions: make(map[structs.Mac]*trackedSession)}
+	if config.ArrivalDelayInMinutes > 0 {
+		sd.arrivalDelay = time.Duration(config.ArrivalDelayInMinutes) * time.Minute
+	}
+	if config.GracePeriodInMinutes > 0 {
+		sd.gracePeriod = time.Duration(config.GracePeriodInMinutes) * time.Minute
+	}
+	return &sd
+}
+
+// filter remembers the current sessions and returns the ones that count as present. These are the current sessions
+// (in the given order) followed by the disappeared sessions within their grace period (sorted by mac).
+func (sd *sessionDebouncer) filter(now time.Time, current []structs.WifiSession) []structs.WifiSession {
+	currentMacs := make(map[structs.Mac]bool)
+	for _, session := range current {
+		currentMacs[session.Mac] = true
+		tracked, ok := sd.sessions[session.Mac]
+		if !ok {
+			tracked = &trackedSession{firstSeen: now}
+			sd.sessions[session.Mac] = tracked
+		}
+		tracked.session = session
+		tracked.lastSeen = now
+	}
+
+	present := make([]structs.WifiSession, 0, len(sd.sessions))
+	for _, session := range current {
+		if sd.hasArrived(sd.sessions[session.Mac]) {
+			present = append(present, session)
+		}
+	}
+
+	lingering := make([]structs.Mac, 0)
+	for mac, tracked := range sd.sessions {
+		if currentMacs[mac] {
+			continue
+		}
+		if now.Sub(tracked.lastSeen) > sd.gracePeriod {
+			delete(sd.sessions, mac)
+			continue
+		}
+		if sd.hasArrived(tracked) {
+			lingering = append(lingering, mac)
+		}
+	}
+	sort.Slice(lingering, func(i, j int) bool {
+		return lingering[i] < lingering[j]
+	})
+	for _, mac := range lingering {
+		present = append(present, sd.sessions[mac].session)
+	}
+
+	return present
+}
+
+// hasArrived is true if the mac was seen (with gaps less than the grace period) for at least the arrival delay
+func (sd *sessionDebouncer) hasArrived(tracked *trackedSession) bool {
+	return tracked.lastSeen.Sub(tracked.firstSeen) >= sd.arrivalDelay
+}
@@ -0,0 +1,67 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_debouncer_gracePeriod(t *testing.T) {
+	assert := assert.New(t)
+	sd := newSessionDebouncer(conf.DebounceConf{GracePeriodInMinutes: 10})
+	t0 := time.Date(2019, 3, 5, 18, 0, 0, 0, time.UTC)
+
+	assert.Equal([]string{"01", "02"}, lastMacs(sd.filter(t0, wifiSessions("01", "02"))))
+	// 02 is sleeping
+	assert.Equal([]string{"01", "02"}, lastMacs(sd.filter(t0.Add(5*time.Minute), wifiSessions("01"))))
+	assert.Equal([]string{"01", "02"}, lastMacs(sd.filter(t0.Add(10*time.Minute), wifiSessions("01"))))
+	// and gone
+	assert.Equal([]string{"01"}, lastMacs(sd.filter(t0.Add(11*time.Minute), wifiSessions("01"))))
+	// back again
+	assert.Equal([]string{"02", "01"}, lastMacs(sd.filter(t0.Add(12*time.Minute), wifiSessions("02", "01"))))
+}
+
+func Test_debouncer_arrivalDelay(t *testing.T) {
+	assert := assert.New(t)
+	sd := newSessionDebouncer(conf.DebounceConf{ArrivalDelayInMinutes: 2, GracePeriodInMinutes: 10})
+	t0 := time.Date(2019, 3, 5, 18, 0, 0, 0, time.UTC)
+
+	assert.Equal([]string{}, lastMacs(sd.filter(t0, wifiSessions("01", "02"))))
+	assert.Equal([]string{}, lastMacs(sd.filter(t0.Add(1*time.Minute), wifiSessions("01"))))
+	assert.Equal([]string{"01"}, lastMacs(sd.filter(t0.Add(2*time.Minute), wifiSessions("01"))))
+	// the drive-by device 02 never counts, even within its grace period
+	assert.Equal([]string{"01"}, lastMacs(sd.filter(t0.Add(5*time.Minute), wifiSessions("01"))))
+	// the sleeping 01 still counts
+	assert.Equal([]string{"01"}, lastMacs(sd.filter(t0.Add(8*time.Minute), wifiSessions())))
+	assert.Equal([]string{}, lastMacs(sd.filter(t0.Add(19*time.Minute), wifiSessions())))
+}
+
+func Test_debouncer_keepsLastSession(t *testing.T) {
+	assert := assert.New(t)
+	sd := newSessionDebouncer(conf.DebounceConf{GracePeriodInMinutes: 10})
+	t0 := time.Date(2019, 3, 5, 18, 0, 0, 0, time.UTC)
+
+	sd.filter(t0, []structs.WifiSession{{Mac: "00:00:00:00:00:01", AP: 1, Location: "Bar"}})
+	sd.filter(t0.Add(time.Minute), []structs.WifiSession{{Mac: "00:00:00:00:00:01", AP: 4, Location: "Club"}})
+	present := sd.filter(t0.Add(2*time.Minute), []structs.WifiSession{})
+	assert.Equal([]structs.WifiSession{{Mac: "00:00:00:00:00:01", AP: 4, Location: "Club"}}, present)
+}
+
+func wifiSessions(lastMacs ...string) []structs.WifiSession {
+	result := make([]structs.WifiSession, 0, len(lastMacs))
+	for _, lastMac := range lastMacs {
+		result = append(result, structs.WifiSession{Mac: structs.Mac("00:00:00:00:00:" + lastMac)})
+	}
+	return result
+}
+
+func lastMacs(sessions []structs.WifiSession) []string {
+	result := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, session.Mac.String()[len(session.Mac)-2:])
+	}
+	return result
+}
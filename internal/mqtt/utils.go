@@ -2,8 +2,9 @@ package mqtt
 
 import (
 	"crypto/rand"
-	"github.com/sirupsen/logrus"
 	"encoding/base64"
+	"github.com/sirupsen/logrus"
+	"time"
 )
 
 // https://elithrar.github.io/article/generating-secure-random-numbers-crypto-rand/
@@ -29,3 +30,8 @@ func GenerateRandomString(s int) string {
 	b := GenerateRandomBytes(s)
 	return base64.URLEncoding.EncodeToString(b)
 }
+
+// toMs returns the unix time in ms
+func toMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
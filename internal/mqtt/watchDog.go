@@ -2,35 +2,63 @@ package mqtt
 
 import (
 	"time"
+
 	"github.com/sirupsen/logrus"
-	"os"
 )
 
+// the watch dog checks for missing pings in this interval
+const watchDogCheckInterval = 60 * time.Second
+
+// recoveryStage is executed once, if the last ping is older than 'after'
+type recoveryStage struct {
+	name   string
+	after  time.Duration
+	action func()
+}
+
 type watchDog struct {
-	timeout time.Duration
-	ping    chan struct{}
-	stop    chan struct{}
+	// sorted by 'after'
+	stages []recoveryStage
+	now    func() time.Time
+	ping   chan struct{}
+	stop   chan struct{}
+
+	// only used within the loop
+	lastKeepAlive time.Time
+	nextStage     int
 }
 
 var wdLogger = logrus.WithField("where", "watchDog")
 
 // NewWatchDog creates a new watch dog
-// timeout - after this amount of time without a ping, the program will be killed and returns 3
-func NewWatchDog(timeout time.Duration) *watchDog {
-	wd := watchDog{
-		timeout: timeout,
-		ping:    make(chan struct{}),
-		stop:    make(chan struct{}),
-	}
+// stages - the recovery actions, executed one after another (at most one per check) if no ping arrives. A ping resets
+// the watch dog to the first stage.
+func NewWatchDog(stages []recoveryStage) *watchDog {
+	wd := newWatchDog(stages, time.Now)
+	ticker := time.NewTicker(watchDogCheckInterval)
 
-	go wd.loop()
+	go wd.loop(ticker.C, ticker.Stop)
 
-	return &wd
+	return wd
 }
 
-// Ping updates the watch dog timeout
+func newWatchDog(stages []recoveryStage, now func() time.Time) *watchDog {
+	return &watchDog{
+		stages:        stages,
+		now:           now,
+		ping:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		lastKeepAlive: now(),
+	}
+}
+
+// Ping updates the watch dog timeout. It never blocks.
 func (wd *watchDog) Ping() {
-	wd.ping <- struct{}{}
+	select {
+	case wd.ping <- struct{}{}:
+	default:
+		// there is already a pending ping
+	}
 }
 
 func (wd *watchDog) Stop() {
@@ -38,23 +66,42 @@ func (wd *watchDog) Stop() {
 	wd.stop <- struct{}{}
 }
 
-func (wd *watchDog) loop() {
-	timer := time.NewTicker(60 * time.Second)
-	lastKeepAlive := time.Now()
-
+func (wd *watchDog) loop(ticks <-chan time.Time, stopTicks func()) {
 	for {
 		select {
-		case <-timer.C:
-			if time.Since(lastKeepAlive) > wd.timeout {
-				wdLogger.Errorf("Last keep alive (%s) is older than allowed timeout (%s). Exit!",
-					lastKeepAlive, wd.timeout)
-				os.Exit(3)
-			}
+		case <-ticks:
+			wd.check()
 		case <-wd.ping:
-			lastKeepAlive = time.Now()
+			wd.keepAlive()
 		case <-wd.stop:
-			timer.Stop()
+			stopTicks()
 			return
 		}
 	}
 }
+
+func (wd *watchDog) keepAlive() {
+	if wd.nextStage > 0 {
+		wdLogger.WithField("stage", wd.stages[wd.nextStage-1].name).Info("Got a ping again, recovered.")
+	}
+	wd.lastKeepAlive = wd.now()
+	wd.nextStage = 0
+}
+
+func (wd *watchDog) check() {
+	if wd.nextStage >= len(wd.stages) {
+		return
+	}
+
+	stage := wd.stages[wd.nextStage]
+	if wd.now().Sub(wd.lastKeepAlive) <= stage.after {
+		return
+	}
+
+	wdLogger.WithFields(logrus.Fields{
+		"stage":         stage.name,
+		"lastKeepAlive": wd.lastKeepAlive,
+	}).Warn("Last keep alive is too old, starting recovery stage.")
+	wd.nextStage++
+	stage.action()
+}
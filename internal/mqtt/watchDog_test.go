@@ -0,0 +1,123 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func Test_watchDog_stages(t *testing.T) {
+	a := assert.New(t)
+
+	var executed []string
+	stage := func(name string, after time.Duration) recoveryStage {
+		return recoveryStage{name: name, after: after, action: func() {
+			executed = append(executed, name)
+		}}
+	}
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	wd := newWatchDog([]recoveryStage{
+		stage("first", 5*time.Minute),
+		stage("second", 10*time.Minute),
+	}, clock.Now)
+
+	clock.add(5 * time.Minute)
+	wd.check()
+	a.Empty(executed)
+
+	clock.add(time.Minute)
+	wd.check()
+	a.Equal([]string{"first"}, executed)
+	// every stage only once
+	wd.check()
+	a.Equal([]string{"first"}, executed)
+
+	clock.add(5 * time.Minute)
+	wd.check()
+	a.Equal([]string{"first", "second"}, executed)
+	wd.check()
+	a.Equal([]string{"first", "second"}, executed)
+
+	// starts with the first stage again
+	wd.keepAlive()
+	clock.add(6 * time.Minute)
+	wd.check()
+	a.Equal([]string{"first", "second", "first"}, executed)
+}
+
+func Test_watchDog_oneStagePerCheck(t *testing.T) {
+	a := assert.New(t)
+
+	var executed []string
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	wd := newWatchDog([]recoveryStage{
+		{name: "first", after: time.Minute, action: func() { executed = append(executed, "first") }},
+		{name: "second", after: 2 * time.Minute, action: func() { executed = append(executed, "second") }},
+	}, clock.Now)
+
+	clock.add(time.Hour)
+	wd.check()
+	a.Equal([]string{"first"}, executed)
+	wd.check()
+	a.Equal([]string{"first", "second"}, executed)
+}
+
+func Test_watchDog_loop(t *testing.T) {
+	a := assert.New(t)
+
+	executed := make(chan string, 1)
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	wd := newWatchDog([]recoveryStage{
+		{name: "first", after: time.Minute, action: func() { executed <- "first" }},
+	}, clock.Now)
+	// never blocks, even without the loop
+	wd.Ping()
+	wd.Ping()
+	<-wd.ping
+
+	ticks := make(chan time.Time)
+	stopped := make(chan struct{})
+	go wd.loop(ticks, func() { close(stopped) })
+
+	clock.add(2 * time.Minute)
+	ticks <- clock.now
+	a.Equal("first", <-executed)
+
+	wd.Stop()
+	<-stopped
+}
+
+func Test_recoveryStages(t *testing.T) {
+	a := assert.New(t)
+
+	h := MqttHandler{}
+	names := func(stages []recoveryStage) []string {
+		var result []string
+		for _, stage := range stages {
+			result = append(result, stage.name)
+		}
+		return result
+	}
+
+	a.Equal([]string{"resubscribe", "reconnect", "publishStale"}, names(h.recoveryStages(5*time.Minute, 0)))
+	a.Equal([]string{"resubscribe", "reconnect", "publishStale", "exit"}, names(h.recoveryStages(5*time.Minute, time.Hour)))
+	// the exit is never before the stale devices are published
+	stages := h.recoveryStages(5*time.Minute, 7*time.Minute)
+	a.Equal([]string{"resubscribe", "reconnect", "publishStale", "exit"}, names(stages))
+	a.Equal(16*time.Minute, stages[3].after)
+	a.Equal([]string{"exit"}, names(h.recoveryStages(0, time.Hour)))
+	a.Empty(h.recoveryStages(0, 0))
+}
@@ -0,0 +1,87 @@
+package mqtt
+
+import (
+	"encoding/json"
+
+	"github.com/ktt-ol/spaceDevices/internal/metrics"
+	"github.com/ktt-ol/spaceDevices/internal/sources"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/sirupsen/logrus"
+)
+
+// wifiSessionSource delivers the sessions of our access points, received from the mqtt session topic
+type wifiSessionSource struct {
+	handler  *MqttHandler
+	sessions chan []structs.WifiSession
+	stop     chan struct{}
+}
+
+func NewWifiSessionSource(handler *MqttHandler) sources.SessionSource {
+	source := wifiSessionSource{handler: handler, sessions: make(chan []structs.WifiSession), stop: make(chan struct{})}
+
+	go source.loop()
+
+	return &source
+}
+
+func (s *wifiSessionSource) Name() string {
+	return "wifi"
+}
+
+func (s *wifiSessionSource) Sessions() <-chan []structs.WifiSession {
+	return s.sessions
+}
+
+func (s *wifiSessionSource) Stop() {
+	close(s.stop)
+}
+
+func (s *wifiSessionSource) loop() {
+	for {
+		var data []byte
+		select {
+		case data = <-s.handler.GetNewDataChannel():
+		case <-s.stop:
+			return
+		}
+		sessionsList := unmarshalWifiSessions(data)
+		if sessionsList == nil {
+			s.handler.countParseError()
+			continue
+		}
+		select {
+		case s.sessions <- sessionsList:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// unmarshalWifiSessions returns nil, if the data is invalid. Single invalid sessions (e.g. with an invalid mac) are
+// skipped.
+func unmarshalWifiSessions(rawData []byte) []structs.WifiSession {
+	var rawSessions []json.RawMessage
+	if err := json.Unmarshal(rawData, &rawSessions); err != nil || rawSessions == nil {
+		ddLogger.WithFields(logrus.Fields{
+			"rawData": string(rawData),
+			"error":   err,
+		}).Error("Unable to unmarshal wifi session json.")
+		metrics.SessionParseErrors.Inc()
+		return nil
+	}
+
+	sessionData := make([]structs.WifiSession, 0, len(rawSessions))
+	for _, rawSession := range rawSessions {
+		var session structs.WifiSession
+		if err := json.Unmarshal(rawSession, &session); err != nil {
+			ddLogger.WithFields(logrus.Fields{
+				"rawSession": string(rawSession),
+				"error":      err,
+			}).Warn("Skipping invalid wifi session.")
+			continue
+		}
+		sessionData = append(sessionData, session)
+	}
+
+	return sessionData
+}
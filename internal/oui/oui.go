@@ -0,0 +1,151 @@
+package oui
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+)
+
+// the header of the IEEE registry files (oui.csv, mam.csv and oui36.csv)
+const registryHeader = "Registry,"
+
+// the lengths of the assignments in hex digits, the longest first: MA-S (36 bit), MA-M (28 bit) and MA-L (24 bit)
+var assignmentLengths = [...]int{9, 7, 6}
+
+// Db maps the assigned mac prefixes of the IEEE registries to the vendor names
+type Db struct {
+	// the upper case assignment, e.g. "5C514F", to the vendor name
+	vendors map[string]string
+}
+
+func New() *Db {
+	return &Db{vendors: make(map[string]string)}
+}
+
+// Load reads all given files, the IEEE registries and the converted format can be mixed
+func Load(fileNames ...string) (*Db, error) {
+	ouiDb := New()
+	for _, fileName := range fileNames {
+		content, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if err = ouiDb.Read(bytes.NewReader(content)); err != nil {
+			return nil, fmt.Errorf("%s: %s", fileName, err)
+		}
+	}
+	return ouiDb, nil
+}
+
+// Read adds the entries of an IEEE registry file or the converted format ("5C514F,Vendor Name" per line). Lines with an
+// invalid assignment are skipped.
+func (d *Db) Read(reader io.Reader) error {
+	buffered := bufio.NewReader(reader)
+	start, _ := buffered.Peek(len(registryHeader) + 3)
+	// the utf-8 byte order mark is optional
+	start = bytes.TrimPrefix(start, []byte("\xef\xbb\xbf"))
+	if bytes.HasPrefix(start, []byte(registryHeader)) {
+		return d.readRegistry(buffered)
+	}
+	return d.readConverted(buffered)
+}
+
+func (d *Db) readRegistry(reader io.Reader) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+	// the header
+	if _, err := csvReader.Read(); err != nil {
+		return err
+	}
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) >= 3 {
+			d.add(record[1], record[2])
+		}
+	}
+}
+
+func (d *Db) readConverted(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ",", 2)
+		if len(parts) == 2 {
+			d.add(parts[0], parts[1])
+		}
+	}
+	return scanner.Err()
+}
+
+func (d *Db) add(assignment string, vendor string) {
+	assignment = strings.ToUpper(strings.TrimSpace(assignment))
+	if !isAssignment(assignment) {
+		return
+	}
+	d.vendors[assignment] = strings.Join(strings.Fields(vendor), " ")
+}
+
+func isAssignment(assignment string) bool {
+	for _, length := range assignmentLengths {
+		if len(assignment) == length {
+			return strings.Trim(assignment, "0123456789ABCDEF") == ""
+		}
+	}
+	return false
+}
+
+// Lookup returns the vendor of the mac, the longest assignment wins. Randomized (locally administered) macs have no
+// vendor. Works on a nil Db.
+func (d *Db) Lookup(mac structs.Mac) (string, bool) {
+	if d == nil || mac.IsLocallyAdministered() {
+		return "", false
+	}
+	hex := strings.ToUpper(strings.Replace(mac.String(), ":", "", -1))
+	if len(hex) != 12 {
+		return "", false
+	}
+	for _, length := range assignmentLengths {
+		if vendor, ok := d.vendors[hex[:length]]; ok {
+			return vendor, true
+		}
+	}
+	return "", false
+}
+
+// Count returns the amount of assignments
+func (d *Db) Count() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.vendors)
+}
+
+// WriteConverted writes all entries sorted in the converted format
+func (d *Db) WriteConverted(writer io.Writer) error {
+	assignments := make([]string, 0, len(d.vendors))
+	for assignment := range d.vendors {
+		assignments = append(assignments, assignment)
+	}
+	sort.Strings(assignments)
+
+	buffered := bufio.NewWriter(writer)
+	for _, assignment := range assignments {
+		if _, err := fmt.Fprintf(buffered, "%s,%s\n", assignment, d.vendors[assignment]); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
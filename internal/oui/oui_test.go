@@ -0,0 +1,95 @@
+package oui
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+const testOui = "Registry,Assignment,Organization Name,Organization Address\r\n" +
+	"MA-L,5C514F,Intel Corporate,Lot 8 Jalan Hi-Tech 2/3  Kulim Kedah  MY 09000 \r\n" +
+	"MA-L,F0D5BF,\"Intel, Corporate\",\"Lot 8, Jalan Hi-Tech 2/3\"\r\n" +
+	"MA-L,70B3D5,IEEE Registration Authority,445 Hoes Lane  Piscataway  NJ  US  08554 \r\n"
+
+const testMam = "\xef\xbb\xbfRegistry,Assignment,Organization Name,Organization Address\n" +
+	"MA-M,70B3D51,Some Small Vendor,Street\n"
+
+const testOui36 = "Registry,Assignment,Organization Name,Organization Address\n" +
+	"MA-S,70B3D5123,Tiny   Vendor,Street\n"
+
+func Test_Read(t *testing.T) {
+	a := assert.New(t)
+
+	ouiDb := New()
+	a.NoError(ouiDb.Read(strings.NewReader(testOui)))
+	a.NoError(ouiDb.Read(strings.NewReader(testMam)))
+	a.NoError(ouiDb.Read(strings.NewReader(testOui36)))
+	a.Equal(5, ouiDb.Count())
+
+	check := func(mac string, expected string) {
+		vendor, ok := ouiDb.Lookup(structs.Mac(mac))
+		a.Equal(expected != "", ok, mac)
+		a.Equal(expected, vendor, mac)
+	}
+	check("5c:51:4f:00:00:01", "Intel Corporate")
+	check("f0:d5:bf:00:00:01", "Intel, Corporate")
+	check("70:b3:d5:00:00:01", "IEEE Registration Authority")
+	check("70:b3:d5:10:00:01", "Some Small Vendor")
+	check("70:b3:d5:12:30:01", "Tiny Vendor")
+	check("00:00:00:00:00:01", "")
+	// randomized
+	check("5e:51:4f:00:00:01", "")
+}
+
+func Test_converted(t *testing.T) {
+	a := assert.New(t)
+
+	ouiDb := New()
+	a.NoError(ouiDb.Read(strings.NewReader(testOui)))
+	a.NoError(ouiDb.Read(strings.NewReader(testOui36)))
+	var converted bytes.Buffer
+	a.NoError(ouiDb.WriteConverted(&converted))
+	a.Equal("5C514F,Intel Corporate\n70B3D5,IEEE Registration Authority\n70B3D5123,Tiny Vendor\nF0D5BF,Intel, Corporate\n",
+		converted.String())
+
+	// invalid lines are skipped
+	readAgain := New()
+	a.NoError(readAgain.Read(strings.NewReader(converted.String() + "short\n\nXYZXYZ,Invalid\n5c514e,lower case\n")))
+	a.Equal(5, readAgain.Count())
+	vendor, ok := readAgain.Lookup("70:b3:d5:12:30:01")
+	a.True(ok)
+	a.Equal("Tiny Vendor", vendor)
+	vendor, ok = readAgain.Lookup("5c:51:4e:00:00:01")
+	a.True(ok)
+	a.Equal("lower case", vendor)
+}
+
+func Test_Load(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "oui")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	ouiFile := filepath.Join(dir, "oui.csv")
+	convertedFile := filepath.Join(dir, "macVendorDb.csv")
+	a.NoError(ioutil.WriteFile(ouiFile, []byte(testOui), 0644))
+	a.NoError(ioutil.WriteFile(convertedFile, []byte("70B3D51,Some Small Vendor\n"), 0644))
+
+	ouiDb, err := Load(ouiFile, convertedFile)
+	a.NoError(err)
+	a.Equal(4, ouiDb.Count())
+
+	_, err = Load(filepath.Join(dir, "missing.csv"))
+	a.Error(err)
+
+	// nothing loaded
+	var empty *Db
+	_, ok := empty.Lookup("5c:51:4f:00:00:01")
+	a.False(ok)
+	a.Equal(0, empty.Count())
+}
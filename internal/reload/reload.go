@@ -0,0 +1,114 @@
+package reload
+
+import (
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.WithField("where", "reload")
+
+// fileState is used to detect a changed file without reading it
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// Reloader calls the reload function whenever one of the watched files changed (polled in a fixed interval) or the
+// reload is triggered, e.g. by a SIGHUP. The reload function is never called concurrently.
+type Reloader struct {
+	fileNames []string
+	states    []fileState
+	reload    func()
+	trigger   chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewReloader starts watching the files. If the interval is < 1, the files aren't polled and only Trigger reloads.
+func NewReloader(interval time.Duration, reload func(), fileNames ...string) *Reloader {
+	r := newReloader(reload, fileNames)
+	if interval <= 0 {
+		go r.loop(nil)
+		return r
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		r.loop(ticker.C)
+	}()
+	return r
+}
+
+func newReloader(reload func(), fileNames []string) *Reloader {
+	r := &Reloader{fileNames: fileNames, reload: reload, trigger: make(chan struct{}, 1), stop: make(chan struct{}),
+		done: make(chan struct{})}
+	r.states = r.currentStates()
+	return r
+}
+
+// Trigger forces a reload, even if no file changed
+func (r *Reloader) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+		// a reload is already pending
+	}
+}
+
+// Stop ends the watching and waits for a running reload
+func (r *Reloader) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Reloader) loop(ticks <-chan time.Time) {
+	defer close(r.done)
+	for {
+		select {
+		case <-ticks:
+			if !r.changed() {
+				continue
+			}
+			logger.Info("Files changed, reloading.")
+		case <-r.trigger:
+			// the files are reloaded anyway
+			r.changed()
+			logger.Info("Reload triggered.")
+		case <-r.stop:
+			return
+		}
+		r.reload()
+	}
+}
+
+// changed returns true, if any file changed since the last call
+func (r *Reloader) changed() bool {
+	states := r.currentStates()
+	changed := false
+	for i := range states {
+		if states[i] != r.states[i] {
+			changed = true
+		}
+	}
+	r.states = states
+	return changed
+}
+
+func (r *Reloader) currentStates() []fileState {
+	states := make([]fileState, len(r.fileNames))
+	for i, fileName := range r.fileNames {
+		info, err := os.Stat(fileName)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logger.WithError(err).WithField("file", fileName).Warn("Can't check the file.")
+			}
+			continue
+		}
+		states[i] = fileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+	}
+	return states
+}
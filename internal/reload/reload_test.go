@@ -0,0 +1,39 @@
+package reload
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Reloader(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "reload")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "masterDb.json")
+	a.NoError(ioutil.WriteFile(fileName, []byte("{}"), 0644))
+
+	reloads := make(chan struct{}, 10)
+	r := newReloader(func() { reloads <- struct{}{} }, []string{fileName, filepath.Join(dir, "missing.toml")})
+	ticks := make(chan time.Time)
+	go r.loop(ticks)
+
+	// nothing changed, the second tick is received after the first one was handled
+	ticks <- time.Now()
+	ticks <- time.Now()
+	a.Len(reloads, 0)
+
+	a.NoError(ioutil.WriteFile(fileName, []byte(`{"00:00:00:00:00:01": {}}`), 0644))
+	ticks <- time.Now()
+	<-reloads
+
+	r.Trigger()
+	<-reloads
+
+	r.Stop()
+}
@@ -0,0 +1,163 @@
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+)
+
+const procNetArp = "/proc/net/arp"
+
+// used for incomplete entries
+const emptyMac structs.Mac = "00:00:00:00:00:00"
+
+// the neighbor states of `ip neigh` that count as present. STALE is left out, because such entries stay in the table
+// long after the device is gone.
+var presentNeighborStates = map[string]bool{"REACHABLE": true, "DELAY": true, "PROBE": true, "PERMANENT": true}
+
+// NewArpSource creates a source for the neighbors of the linux host, either from /proc/net/arp (ipv4 only) or the
+// output of `ip neigh show` (ipv4 and ipv6).
+func NewArpSource(config conf.ArpSourceConf) SessionSource {
+	var read readFunc
+	switch config.Mode {
+	case "ip":
+		read = func() ([]structs.WifiSession, error) {
+			output, err := exec.Command("ip", "neigh", "show").Output()
+			if err != nil {
+				return nil, err
+			}
+			return parseIpNeigh(bytes.NewReader(output), config.Interface)
+		}
+	case "proc", "":
+		read = func() ([]structs.WifiSession, error) {
+			file, err := os.Open(procNetArp)
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+			return parseProcNetArp(file, config.Interface)
+		}
+	default:
+		logger.WithField("mode", config.Mode).Fatal("Invalid arp source mode, must be 'proc' or 'ip'.")
+	}
+
+	return newPollingSource("arp", intervalOrDefault(config.IntervalInSeconds), config.Location, read)
+}
+
+// parseProcNetArp parses the content of /proc/net/arp, e.g.
+// IP address       HW type     Flags       HW address            Mask     Device
+// 192.168.2.1      0x1         0x2         00:11:22:33:44:55     *        eth0
+// If iface is not empty, only the entries of that interface are returned.
+func parseProcNetArp(reader io.Reader, iface string) ([]structs.WifiSession, error) {
+	const flagComplete = 0x2
+
+	sessions := make([]structs.WifiSession, 0)
+	scanner := bufio.NewScanner(reader)
+	first := true
+	for scanner.Scan() {
+		if first {
+			// the header
+			first = false
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		if iface != "" && fields[5] != iface {
+			continue
+		}
+		var flags int
+		if _, err := fmt.Sscanf(fields[2], "0x%x", &flags); err != nil || flags&flagComplete == 0 {
+			continue
+		}
+		mac, ok := parseNeighborMac(fields[3])
+		if !ok {
+			continue
+		}
+		sessions = append(sessions, structs.WifiSession{Ipv4: fields[0], Ipv6: []string{}, Mac: mac})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return Merge(sessions), nil
+}
+
+// parseIpNeigh parses the output of `ip neigh show`, e.g.
+// 192.168.2.1 dev eth0 lladdr 00:11:22:33:44:55 REACHABLE
+// fe80::1 dev eth0 lladdr 00:11:22:33:44:55 router STALE
+// If iface is not empty, only the entries of that interface are returned.
+func parseIpNeigh(reader io.Reader, iface string) ([]structs.WifiSession, error) {
+	sessions := make([]structs.WifiSession, 0)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		state := fields[len(fields)-1]
+		if !presentNeighborStates[state] {
+			continue
+		}
+
+		var dev, lladdr string
+		for i := 1; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "dev":
+				dev = fields[i+1]
+			case "lladdr":
+				lladdr = fields[i+1]
+			}
+		}
+		if iface != "" && dev != iface {
+			continue
+		}
+		mac, ok := parseNeighborMac(lladdr)
+		if !ok {
+			continue
+		}
+
+		session := structs.WifiSession{Ipv6: []string{}, Mac: mac}
+		if ip.To4() != nil {
+			session.Ipv4 = fields[0]
+		} else {
+			session.Ipv6 = []string{fields[0]}
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return Merge(sessions), nil
+}
+
+// parseNeighborMac returns the canonical mac or false for invalid and empty (00:00:00:00:00:00) addresses.
+func parseNeighborMac(value string) (structs.Mac, bool) {
+	mac, err := structs.ParseMac(value)
+	if err != nil || mac == emptyMac {
+		return "", false
+	}
+	return mac, true
+}
+
+func intervalOrDefault(intervalInSeconds int) time.Duration {
+	if intervalInSeconds < 1 {
+		return time.Minute
+	}
+	return time.Duration(intervalInSeconds) * time.Second
+}
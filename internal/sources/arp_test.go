@@ -0,0 +1,52 @@
+package sources
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseProcNetArp(t *testing.T) {
+	const procArp = `IP address       HW type     Flags       HW address            Mask     Device
+192.168.2.1      0x1         0x2         00:11:22:33:44:55     *        eth0
+192.168.2.7      0x1         0x2         AA:BB:CC:DD:EE:FF     *        eth0
+192.168.2.8      0x1         0x0         00:00:00:00:00:00     *        eth0
+10.0.0.5         0x1         0x2         00:11:22:33:44:66     *        wlan0
+`
+	assert := assert.New(t)
+
+	sessions, err := parseProcNetArp(strings.NewReader(procArp), "")
+	assert.NoError(err)
+	assert.Equal(3, len(sessions))
+
+	sessions, err = parseProcNetArp(strings.NewReader(procArp), "eth0")
+	assert.NoError(err)
+	assert.Equal([]structs.WifiSession{
+		{Ipv4: "192.168.2.1", Ipv6: []string{}, Mac: "00:11:22:33:44:55"},
+		{Ipv4: "192.168.2.7", Ipv6: []string{}, Mac: "aa:bb:cc:dd:ee:ff"},
+	}, sessions)
+}
+
+func Test_parseIpNeigh(t *testing.T) {
+	const ipNeigh = `192.168.2.1 dev eth0 lladdr 00:11:22:33:44:55 REACHABLE
+fe80::211:22ff:fe33:4455 dev eth0 lladdr 00:11:22:33:44:55 router DELAY
+192.168.2.7 dev eth0 lladdr aa:bb:cc:dd:ee:ff STALE
+192.168.2.8 dev eth0  FAILED
+192.168.2.9 dev eth0 lladdr aa:bb:cc:dd:ee:01 PERMANENT
+10.0.0.5 dev wlan0 lladdr 00:11:22:33:44:66 REACHABLE
+`
+	assert := assert.New(t)
+
+	sessions, err := parseIpNeigh(strings.NewReader(ipNeigh), "eth0")
+	assert.NoError(err)
+	assert.Equal([]structs.WifiSession{
+		{Ipv4: "192.168.2.1", Ipv6: []string{"fe80::211:22ff:fe33:4455"}, Mac: "00:11:22:33:44:55"},
+		{Ipv4: "192.168.2.9", Ipv6: []string{}, Mac: "aa:bb:cc:dd:ee:01"},
+	}, sessions)
+
+	sessions, err = parseIpNeigh(strings.NewReader(ipNeigh), "")
+	assert.NoError(err)
+	assert.Equal(3, len(sessions))
+}
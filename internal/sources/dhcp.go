@@ -0,0 +1,161 @@
+package sources
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+)
+
+// NewDhcpSource creates a source for the active leases of an ISC dhcpd ("isc") or dnsmasq ("dnsmasq") lease file.
+// Be aware that a lease is still active for a while after the device is gone, so choose a short lease time.
+func NewDhcpSource(config conf.DhcpSourceConf) SessionSource {
+	var parse func(reader io.Reader, now time.Time) ([]structs.WifiSession, error)
+	switch config.Format {
+	case "isc":
+		parse = parseIscLeases
+	case "dnsmasq":
+		parse = parseDnsmasqLeases
+	default:
+		logger.WithField("format", config.Format).Fatal("Invalid dhcp lease format, must be 'isc' or 'dnsmasq'.")
+	}
+
+	read := func() ([]structs.WifiSession, error) {
+		file, err := os.Open(config.LeaseFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return parse(file, time.Now())
+	}
+	return newPollingSource("dhcp", intervalOrDefault(config.IntervalInSeconds), config.Location, read)
+}
+
+type iscLease struct {
+	ip     string
+	mac    string
+	ends   time.Time
+	active bool
+}
+
+// parseIscLeases parses a dhcpd.leases file, e.g.
+//
+//	lease 192.168.2.100 {
+//	  starts 4 2019/03/07 10:00:00;
+//	  ends 4 2019/03/07 22:00:00;
+//	  binding state active;
+//	  hardware ethernet 00:11:22:33:44:55;
+//	}
+//
+// The file is append-only, thus the last lease of an ip wins.
+func parseIscLeases(reader io.Reader, now time.Time) ([]structs.WifiSession, error) {
+	leases := make(map[string]*iscLease)
+	ipOrder := make([]string, 0)
+
+	var current *iscLease
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSuffix(line, ";")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if current == nil {
+			if fields[0] == "lease" && len(fields) >= 3 && fields[2] == "{" {
+				// without a binding state (old dhcpd versions), the lease counts as active
+				current = &iscLease{ip: fields[1], active: true}
+			}
+			continue
+		}
+
+		switch {
+		case fields[0] == "}":
+			if _, ok := leases[current.ip]; !ok {
+				ipOrder = append(ipOrder, current.ip)
+			}
+			leases[current.ip] = current
+			current = nil
+		case fields[0] == "ends":
+			current.ends = parseIscTime(fields[1:])
+		case fields[0] == "binding" && len(fields) == 3 && fields[1] == "state":
+			current.active = fields[2] == "active"
+		case fields[0] == "hardware" && len(fields) == 3 && fields[1] == "ethernet":
+			current.mac = fields[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]structs.WifiSession, 0)
+	for _, ip := range ipOrder {
+		lease := leases[ip]
+		if !lease.active || (!lease.ends.IsZero() && lease.ends.Before(now)) {
+			continue
+		}
+		mac, ok := parseNeighborMac(lease.mac)
+		if !ok {
+			continue
+		}
+		sessions = append(sessions, structs.WifiSession{Ipv4: lease.ip, Ipv6: []string{}, Mac: mac})
+	}
+
+	return Merge(sessions), nil
+}
+
+// parseIscTime parses "4 2019/03/07 22:00:00" (UTC), "epoch 1551996000" or "never" (returns the zero time).
+func parseIscTime(fields []string) time.Time {
+	if len(fields) == 2 && fields[0] == "epoch" {
+		if epoch, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			return time.Unix(epoch, 0)
+		}
+	}
+	if len(fields) == 3 {
+		if t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2]); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseDnsmasqLeases parses a dnsmasq.leases file, e.g.
+// 1551996000 00:11:22:33:44:55 192.168.2.100 hostname 01:00:11:22:33:44:55
+// An expiry time of 0 means infinite. The ipv6 leases contain no mac and are skipped.
+func parseDnsmasqLeases(reader io.Reader, now time.Time) ([]structs.WifiSession, error) {
+	sessions := make([]structs.WifiSession, 0)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			// e.g. the "duid" line
+			continue
+		}
+		if expiry != 0 && time.Unix(expiry, 0).Before(now) {
+			continue
+		}
+		mac, ok := parseNeighborMac(fields[1])
+		if !ok {
+			continue
+		}
+		sessions = append(sessions, structs.WifiSession{Ipv4: fields[2], Ipv6: []string{}, Mac: mac})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return Merge(sessions), nil
+}
@@ -0,0 +1,74 @@
+package sources
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseIscLeases(t *testing.T) {
+	const leases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.2.100 {
+  starts 4 2019/03/07 10:00:00;
+  ends 4 2019/03/07 22:00:00;
+  binding state active;
+  next binding state free;
+  hardware ethernet 00:11:22:33:44:55;
+  client-hostname "laptop";
+}
+lease 192.168.2.101 {
+  starts 4 2019/03/07 08:00:00;
+  ends 4 2019/03/07 09:00:00;
+  binding state active;
+  hardware ethernet 00:11:22:33:44:66;
+}
+lease 192.168.2.102 {
+  starts 4 2019/03/07 10:00:00;
+  ends never;
+  binding state active;
+  hardware ethernet 00:11:22:33:44:77;
+}
+lease 192.168.2.102 {
+  starts 4 2019/03/07 11:00:00;
+  ends 4 2019/03/07 11:30:00;
+  binding state free;
+  hardware ethernet 00:11:22:33:44:77;
+}
+lease 192.168.2.103 {
+  starts epoch 1551952800;
+  ends epoch 1551996000;
+  binding state active;
+  hardware ethernet 00:11:22:33:44:88;
+}
+`
+	assert := assert.New(t)
+
+	now := time.Date(2019, 3, 7, 12, 0, 0, 0, time.UTC)
+	sessions, err := parseIscLeases(strings.NewReader(leases), now)
+	assert.NoError(err)
+	assert.Equal([]structs.WifiSession{
+		{Ipv4: "192.168.2.100", Ipv6: []string{}, Mac: "00:11:22:33:44:55"},
+		{Ipv4: "192.168.2.103", Ipv6: []string{}, Mac: "00:11:22:33:44:88"},
+	}, sessions)
+}
+
+func Test_parseDnsmasqLeases(t *testing.T) {
+	const leases = `1551996000 00:11:22:33:44:55 192.168.2.100 laptop 01:00:11:22:33:44:55
+1551950000 00:11:22:33:44:66 192.168.2.101 * *
+0 00:11:22:33:44:77 192.168.2.102 printer *
+duid 00:01:00:01:23:45:67:89:00:11:22:33:44:55
+1551996000 1234567 2001:db8::100 laptop 00:01:00:01:23:45:67:89:00:11:22:33:44:55
+`
+	assert := assert.New(t)
+
+	now := time.Date(2019, 3, 7, 12, 0, 0, 0, time.UTC)
+	sessions, err := parseDnsmasqLeases(strings.NewReader(leases), now)
+	assert.NoError(err)
+	assert.Equal([]structs.WifiSession{
+		{Ipv4: "192.168.2.100", Ipv6: []string{}, Mac: "00:11:22:33:44:55"},
+		{Ipv4: "192.168.2.102", Ipv6: []string{}, Mac: "00:11:22:33:44:77"},
+	}, sessions)
+}
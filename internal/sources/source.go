@@ -0,0 +1,127 @@
+package sources
+
+import (
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.WithField("where", "sources")
+
+// SessionSource delivers the current sessions of one kind of network, e.g. the wifi sessions of the access points or
+// the neighbors of the lan.
+type SessionSource interface {
+	// Name identifies the source, e.g. for logging
+	Name() string
+	// Sessions returns the channel that receives the complete list of current sessions, whenever it's known.
+	Sessions() <-chan []structs.WifiSession
+	// Stop ends the source, a pending list is dropped.
+	Stop()
+}
+
+type readFunc func() ([]structs.WifiSession, error)
+
+// pollingSource calls the read function in a fixed interval
+type pollingSource struct {
+	name     string
+	interval time.Duration
+	location string
+	read     readFunc
+	sessions chan []structs.WifiSession
+	stop     chan struct{}
+}
+
+func newPollingSource(name string, interval time.Duration, location string, read readFunc) *pollingSource {
+	source := pollingSource{
+		name:     name,
+		interval: interval,
+		location: location,
+		read:     read,
+		sessions: make(chan []structs.WifiSession),
+		stop:     make(chan struct{}),
+	}
+
+	go source.loop()
+
+	return &source
+}
+
+func (s *pollingSource) Name() string {
+	return s.name
+}
+
+func (s *pollingSource) Sessions() <-chan []structs.WifiSession {
+	return s.sessions
+}
+
+func (s *pollingSource) Stop() {
+	close(s.stop)
+}
+
+func (s *pollingSource) loop() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		sessions, err := s.read()
+		if err != nil {
+			logger.WithError(err).WithField("source", s.name).Error("Could not read sessions.")
+			// the last list is outdated, its devices must not stay present
+			sessions = []structs.WifiSession{}
+		}
+		for i := range sessions {
+			sessions[i].Location = s.location
+		}
+
+		select {
+		case s.sessions <- sessions:
+		case <-s.stop:
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Merge combines the session lists by mac. The first session of a mac determines the access point and location, the
+// ip addresses of all sessions for that mac are combined.
+func Merge(lists ...[]structs.WifiSession) []structs.WifiSession {
+	merged := make([]structs.WifiSession, 0)
+	mac2Index := make(map[structs.Mac]int)
+	for _, list := range lists {
+		for _, session := range list {
+			mac := session.Mac
+			index, ok := mac2Index[mac]
+			if !ok {
+				mac2Index[mac] = len(merged)
+				session.Ipv6 = append([]string{}, session.Ipv6...)
+				merged = append(merged, session)
+				continue
+			}
+
+			existing := &merged[index]
+			if existing.Ipv4 == "" {
+				existing.Ipv4 = session.Ipv4
+			}
+			for _, ipv6 := range session.Ipv6 {
+				if !contains(existing.Ipv6, ipv6) {
+					existing.Ipv6 = append(existing.Ipv6, ipv6)
+				}
+			}
+		}
+	}
+
+	return merged
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package sources
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Merge(t *testing.T) {
+	assert := assert.New(t)
+
+	wifi := []structs.WifiSession{
+		{Ipv4: "10.1.1.1", Ipv6: []string{"fe80::1"}, Mac: "00:00:00:00:00:01", AP: 1, Location: "Bar"},
+		{Ipv4: "10.1.1.2", Ipv6: []string{}, Mac: "00:00:00:00:00:02", AP: 4},
+	}
+	lan := []structs.WifiSession{
+		{Ipv4: "", Ipv6: []string{"fe80::1", "fe80::2"}, Mac: "00:00:00:00:00:01", Location: "Space"},
+		{Ipv4: "10.1.1.3", Ipv6: []string{}, Mac: "00:00:00:00:00:03", Location: "Space"},
+	}
+
+	merged := Merge(wifi, nil, lan)
+	assert.Equal([]structs.WifiSession{
+		{Ipv4: "10.1.1.1", Ipv6: []string{"fe80::1", "fe80::2"}, Mac: "00:00:00:00:00:01", AP: 1, Location: "Bar"},
+		{Ipv4: "10.1.1.2", Ipv6: []string{}, Mac: "00:00:00:00:00:02", AP: 4},
+		{Ipv4: "10.1.1.3", Ipv6: []string{}, Mac: "00:00:00:00:00:03", Location: "Space"},
+	}, merged)
+	// the input is not modified
+	assert.Equal([]string{"fe80::1"}, wifi[0].Ipv6)
+
+	assert.Equal([]structs.WifiSession{}, Merge())
+}
+
+func Test_pollingSource(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	source := newPollingSource("test", time.Millisecond, "Space", func() ([]structs.WifiSession, error) {
+		calls++
+		if calls == 1 {
+			return []structs.WifiSession{{Mac: "00:00:00:00:00:01"}}, nil
+		}
+		return nil, errors.New("not readable")
+	})
+
+	assert.Equal([]structs.WifiSession{{Mac: "00:00:00:00:00:01", Location: "Space"}}, <-source.Sessions())
+	// the devices of a failing source are gone
+	assert.Equal([]structs.WifiSession{}, <-source.Sessions())
+
+	// doesn't block without a receiver
+	source.Stop()
+	select {
+	case <-source.Sessions():
+		assert.Fail("no sessions expected after stop")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
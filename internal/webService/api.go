@@ -0,0 +1,247 @@
+package webService
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/sirupsen/logrus"
+)
+
+// API_CLIENT_HEADER must be set for all changing api requests. Browsers don't send custom headers to other origins
+// without a CORS preflight, which we never allow. Thus an evil page can't change the data of a visitor and the api
+// clients don't need the secToken of the html form.
+const API_CLIENT_HEADER = "X-Requested-With"
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type myDevice struct {
+	Mac                   structs.Mac     `json:"mac"`
+	IsLocallyAdministered bool            `json:"isLocallyAdministered"`
+	Entry                 *db.UserDbEntry `json:"entry"`
+	Person                *db.Person      `json:"person"`
+	// the vendor of the mac and the device type suggested by it, empty if unknown
+	Vendor              string `json:"vendor,omitempty"`
+	SuggestedDeviceType string `json:"suggestedDeviceType,omitempty"`
+}
+
+type myDeviceChange struct {
+	Name       string `json:"name" binding:"required"`
+	DeviceName string `json:"deviceName"`
+	// the default visibility of the person
+	Visibility db.Visibility `json:"visibility" binding:"required"`
+	// optional, overrides the visibility of the person for this device
+	DeviceVisibility db.Visibility `json:"deviceVisibility"`
+	// optional, the visibility of the device for certain times
+	Schedule db.VisibilitySchedule `json:"schedule"`
+	// optional, one of db.UserDeviceTypes
+	DeviceType string `json:"deviceType"`
+}
+
+// the default time range of the history queries
+const defaultHistoryRange = 24 * time.Hour
+
+type historyPeople struct {
+	// unix time in ms
+	From   int64    `json:"from"`
+	To     int64    `json:"to"`
+	People []string `json:"people"`
+}
+
+type lastArrival struct {
+	Name string `json:"name"`
+	// unix time in ms
+	Ts int64 `json:"ts"`
+}
+
+func addApiRoutes(router *gin.Engine) {
+	api := router.Group("/api/v1")
+	api.Use(apiClientCheck)
+	api.GET("/devices", func(c *gin.Context) {
+		c.JSON(http.StatusOK, devices.GetPeopleAndDevices())
+	})
+	api.GET("/me/device", getMyDeviceHandler)
+	api.PUT("/me/device", putMyDeviceHandler)
+	api.DELETE("/me/device", deleteMyDeviceHandler)
+	api.GET("/history/people", getHistoryPeopleHandler)
+	api.GET("/history/lastArrival", getLastArrivalHandler)
+}
+
+func sendApiError(c *gin.Context, status int, msg string) {
+	c.AbortWithStatusJSON(status, apiError{Error: msg})
+}
+
+// apiClientCheck rejects changing requests of browsers from other origins.
+func apiClientCheck(c *gin.Context) {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return
+	}
+
+	if c.GetHeader(API_CLIENT_HEADER) == "" {
+		sendApiError(c, http.StatusForbidden, "Missing "+API_CLIENT_HEADER+" header.")
+		return
+	}
+	if origin := c.GetHeader("Origin"); origin != "" {
+		originUrl, err := url.Parse(origin)
+		if err != nil || originUrl.Host != c.Request.Host {
+			logger.WithField("origin", origin).Warn("Api request from foreign origin.")
+			sendApiError(c, http.StatusForbidden, "Foreign origin.")
+			return
+		}
+	}
+}
+
+// requestMac returns the mac of the requesting device or sends an error and returns false.
+func requestMac(c *gin.Context) (structs.Mac, bool) {
+	ip, _, _ := net.SplitHostPort(c.Request.RemoteAddr)
+	info, ok := devices.GetByIp(ip)
+	if !ok {
+		logger.WithField("ip", ip).Info("No data for ip found.")
+		sendApiError(c, http.StatusNotFound, "No data for your ip found.")
+		return "", false
+	}
+
+	return info.Mac, true
+}
+
+func getMyDeviceHandler(c *gin.Context) {
+	mac, ok := requestMac(c)
+	if !ok {
+		return
+	}
+
+	result := myDevice{Mac: mac, IsLocallyAdministered: mac.IsLocallyAdministered()}
+	result.Vendor, result.SuggestedDeviceType = suggestDeviceType(mac)
+	if entry, ok := macDb.Get(mac); ok {
+		result.Entry = &entry
+		if person, ok := macDb.GetPerson(entry.PersonId); ok {
+			result.Person = &person
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func putMyDeviceHandler(c *gin.Context) {
+	var change myDeviceChange
+	if err := c.ShouldBindJSON(&change); err != nil {
+		logger.WithError(err).Info("Invalid api binding.")
+		sendApiError(c, http.StatusBadRequest, "Invalid data: "+err.Error())
+		return
+	}
+
+	if !change.Visibility.IsUserVisibility() ||
+		(change.DeviceVisibility != db.VisibilityDefault && !change.DeviceVisibility.IsUserVisibility()) {
+		sendApiError(c, http.StatusBadRequest, "Invalid visibility, must be one of ignore, anon, user or all.")
+		return
+	}
+	if err := change.Schedule.Validate(); err != nil {
+		sendApiError(c, http.StatusBadRequest, "Invalid schedule: "+err.Error())
+		return
+	}
+	if !db.IsValidUserDeviceType(change.DeviceType) {
+		sendApiError(c, http.StatusBadRequest, "Invalid deviceType.")
+		return
+	}
+
+	mac, ok := requestMac(c)
+	if !ok {
+		return
+	}
+
+	logger.WithFields(logrus.Fields{"mac": mac, "data": change}).Info("Change user info via api.")
+	entry := saveOwnDevice(mac, change.Name, change.DeviceName, change.DeviceType, change.Visibility, change.DeviceVisibility,
+		change.Schedule)
+	person, _ := macDb.GetPerson(entry.PersonId)
+
+	result := myDevice{Mac: mac, IsLocallyAdministered: mac.IsLocallyAdministered(), Entry: &entry, Person: &person}
+	result.Vendor, result.SuggestedDeviceType = suggestDeviceType(mac)
+	c.JSON(http.StatusOK, result)
+}
+
+func deleteMyDeviceHandler(c *gin.Context) {
+	mac, ok := requestMac(c)
+	if !ok {
+		return
+	}
+
+	logger.WithField("mac", mac).Info("Delete user info via api.")
+	macDb.Delete(mac)
+
+	c.Status(http.StatusNoContent)
+}
+
+// historyEnabled sends an error and returns false, if no presence history is recorded.
+func historyEnabled(c *gin.Context) bool {
+	if presenceHistory == nil {
+		sendApiError(c, http.StatusNotFound, "No history recorded.")
+		return false
+	}
+	return true
+}
+
+// msParam returns the query parameter as time, given in unix time in ms, or the default value if it's missing.
+func msParam(c *gin.Context, name string, defaultValue time.Time) (time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return defaultValue, true
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		sendApiError(c, http.StatusBadRequest, "Invalid "+name+", expected unix time in ms.")
+		return defaultValue, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// toMs returns the unix time in ms
+func toMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// getHistoryPeopleHandler returns the visible people that were present at any time between from and to, the default
+// are the last 24 hours.
+func getHistoryPeopleHandler(c *gin.Context) {
+	if !historyEnabled(c) {
+		return
+	}
+	to, ok := msParam(c, "to", time.Now())
+	if !ok {
+		return
+	}
+	from, ok := msParam(c, "from", to.Add(-defaultHistoryRange))
+	if !ok {
+		return
+	}
+	if from.After(to) {
+		sendApiError(c, http.StatusBadRequest, "from is after to.")
+		return
+	}
+
+	c.JSON(http.StatusOK, historyPeople{From: toMs(from), To: toMs(to), People: presenceHistory.PeopleBetween(from, to)})
+}
+
+// getLastArrivalHandler returns the last arrival of the visible person with the given name
+func getLastArrivalHandler(c *gin.Context) {
+	if !historyEnabled(c) {
+		return
+	}
+	name := c.Query("name")
+	if name == "" {
+		sendApiError(c, http.StatusBadRequest, "Missing name.")
+		return
+	}
+
+	arrival, ok := presenceHistory.LastArrival(name)
+	if !ok {
+		sendApiError(c, http.StatusNotFound, "No arrival found.")
+		return
+	}
+	c.JSON(http.StatusOK, lastArrival{Name: name, Ts: toMs(arrival)})
+}
@@ -0,0 +1,116 @@
+package webService
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/internal/history"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_apiClientCheck(t *testing.T) {
+	a := assert.New(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apiClientCheck)
+	ok := func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	}
+	router.GET("/test", ok)
+	router.PUT("/test", ok)
+
+	request := func(method string, headers map[string]string) int {
+		req := httptest.NewRequest(method, "http://devices.local/test", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	a.Equal(http.StatusNoContent, request("GET", nil))
+	a.Equal(http.StatusForbidden, request("PUT", nil))
+	a.Equal(http.StatusNoContent, request("PUT", map[string]string{API_CLIENT_HEADER: "curl"}))
+	a.Equal(http.StatusNoContent, request("PUT", map[string]string{API_CLIENT_HEADER: "app", "Origin": "http://devices.local"}))
+	a.Equal(http.StatusForbidden, request("PUT", map[string]string{API_CLIENT_HEADER: "app", "Origin": "http://evil.example"}))
+}
+
+func Test_putMyDevice_visibility(t *testing.T) {
+	a := assert.New(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	addApiRoutes(router)
+	put := func(body string) {
+		req := httptest.NewRequest("PUT", "http://devices.local/api/v1/me/device", strings.NewReader(body))
+		req.Header.Set(API_CLIENT_HEADER, "test")
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		a.Equal(http.StatusBadRequest, w.Code, body)
+		a.Contains(w.Body.String(), "visibility", body)
+	}
+
+	// the infrastructure visibilities are reserved for the master db
+	put(`{"name": "hans", "visibility": "critical-infrastructure"}`)
+	put(`{"name": "hans", "visibility": "all", "deviceVisibility": "infrastructure"}`)
+	put(`{"name": "hans", "visibility": "all", "schedule": [{"days": ["mon"], "from": "08:00", "to": "18:00", ` +
+		`"visibility": "important-infrastructure"}]}`)
+}
+
+func Test_historyApi(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "history")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	addApiRoutes(router)
+	request := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "http://devices.local/api/v1/history/"+path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	presenceHistory = nil
+	code, _ := request("people")
+	a.Equal(http.StatusNotFound, code)
+
+	presenceHistory = history.NewPresenceHistory(conf.HistoryConf{File: filepath.Join(dir, "history.log")})
+	defer presenceHistory.Close()
+	t0 := time.Date(2019, 3, 5, 18, 0, 0, 0, time.UTC)
+	presenceHistory.Update(t0, nil, []structs.Person{{Name: "hans"}})
+	presenceHistory.Update(t0.Add(time.Hour), nil, []structs.Person{{Name: "olaf"}})
+	ms := func(t time.Time) string {
+		return strconv.FormatInt(toMs(t), 10)
+	}
+
+	code, body := request("people?from=" + ms(t0.Add(2*time.Hour)) + "&to=" + ms(t0.Add(3*time.Hour)))
+	a.Equal(http.StatusOK, code)
+	a.JSONEq(`{"from":`+ms(t0.Add(2*time.Hour))+`,"to":`+ms(t0.Add(3*time.Hour))+`,"people":["olaf"]}`, body)
+	code, _ = request("people?from=yesterday")
+	a.Equal(http.StatusBadRequest, code)
+	code, _ = request("people?from=" + ms(t0.Add(time.Hour)) + "&to=" + ms(t0))
+	a.Equal(http.StatusBadRequest, code)
+
+	code, body = request("lastArrival?name=hans")
+	a.Equal(http.StatusOK, code)
+	a.JSONEq(`{"name":"hans","ts":`+ms(t0)+`}`, body)
+	code, _ = request("lastArrival?name=herman")
+	a.Equal(http.StatusNotFound, code)
+	code, _ = request("lastArrival")
+	a.Equal(http.StatusBadRequest, code)
+}
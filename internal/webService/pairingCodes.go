@@ -0,0 +1,82 @@
+package webService
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+)
+
+// a pairing code can be used within this time
+const pairingCodeValidity = 10 * time.Minute
+
+const pairingCodeLength = 6
+
+// without similar looking characters, like 0 and O
+var pairingCodeChars = []byte("ABCDEFGHJKLMNPQRSTUVWXYZ23456789")
+
+type pairingEntry struct {
+	mac structs.Mac
+	ts  time.Time
+}
+
+// PairingCodes links a short-lived, one time code to the mac of the device that created it. Another device can use
+// the code to get the same identity.
+type PairingCodes struct {
+	codes map[string]pairingEntry
+	lock  sync.Mutex
+	now   func() time.Time
+}
+
+// NewPairingCodes creates a new instance
+func NewPairingCodes() *PairingCodes {
+	return &PairingCodes{codes: make(map[string]pairingEntry), now: time.Now}
+}
+
+// NewCode returns a new code for the given mac. An older code for the same mac is replaced.
+func (pc *PairingCodes) NewCode(mac structs.Mac) string {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	pc.removeExpired()
+	for code, entry := range pc.codes {
+		if entry.mac == mac {
+			delete(pc.codes, code)
+		}
+	}
+
+	var code string
+	for {
+		code = uniuri.NewLenChars(pairingCodeLength, pairingCodeChars)
+		if _, exists := pc.codes[code]; !exists {
+			break
+		}
+	}
+	pc.codes[code] = pairingEntry{mac: mac, ts: pc.now()}
+	return code
+}
+
+// Redeem returns the mac for the given code. Every code can be used only once.
+func (pc *PairingCodes) Redeem(code string) (structs.Mac, bool) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	pc.removeExpired()
+	entry, ok := pc.codes[code]
+	if !ok {
+		return "", false
+	}
+	delete(pc.codes, code)
+	return entry.mac, true
+}
+
+// the caller must hold the lock
+func (pc *PairingCodes) removeExpired() {
+	oldest := pc.now().Add(-pairingCodeValidity)
+	for code, entry := range pc.codes {
+		if entry.ts.Before(oldest) {
+			delete(pc.codes, code)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package webService
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PairingCodes(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	pc := NewPairingCodes()
+	pc.now = func() time.Time { return now }
+
+	code := pc.NewCode("00:00:00:00:00:01")
+	a.Len(code, pairingCodeLength)
+
+	_, ok := pc.Redeem("unknown")
+	a.False(ok)
+
+	mac, ok := pc.Redeem(code)
+	a.True(ok)
+	a.Equal(structs.Mac("00:00:00:00:00:01"), mac)
+
+	// only once
+	_, ok = pc.Redeem(code)
+	a.False(ok)
+}
+
+func Test_PairingCodes_replaced(t *testing.T) {
+	a := assert.New(t)
+
+	pc := NewPairingCodes()
+	first := pc.NewCode("00:00:00:00:00:01")
+	second := pc.NewCode("00:00:00:00:00:01")
+	other := pc.NewCode("00:00:00:00:00:02")
+
+	_, ok := pc.Redeem(first)
+	a.False(ok)
+	mac, ok := pc.Redeem(second)
+	a.True(ok)
+	a.Equal(structs.Mac("00:00:00:00:00:01"), mac)
+	mac, ok = pc.Redeem(other)
+	a.True(ok)
+	a.Equal(structs.Mac("00:00:00:00:00:02"), mac)
+}
+
+func Test_PairingCodes_expired(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	pc := NewPairingCodes()
+	pc.now = func() time.Time { return now }
+
+	code := pc.NewCode("00:00:00:00:00:01")
+	now = now.Add(pairingCodeValidity + time.Second)
+	_, ok := pc.Redeem(code)
+	a.False(ok)
+}
@@ -0,0 +1,112 @@
+package webService
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/internal/metrics"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/sirupsen/logrus"
+)
+
+// the cookie recognizes a device with a randomized mac after the mac changed
+const reclaimCookieName = "reclaim"
+
+// in seconds, about a year
+const reclaimCookieMaxAge = 365 * 24 * 60 * 60
+
+const reclaimTokenLength = 32
+
+// true, if the cookies are only sent via https
+var secureCookies bool
+
+// hashReclaimToken returns the value stored in the user db, thus the db doesn't contain any valid cookie
+func hashReclaimToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// rememberDevice sets a new re-claim cookie and returns the hash for the user db entry
+func rememberDevice(c *gin.Context) string {
+	token := uniuri.NewLen(reclaimTokenLength)
+	c.SetCookie(reclaimCookieName, token, reclaimCookieMaxAge, "/", "", secureCookies, true)
+	return hashReclaimToken(token)
+}
+
+// forgetDevice removes the re-claim cookie
+func forgetDevice(c *gin.Context) {
+	c.SetCookie(reclaimCookieName, "", -1, "/", "", secureCookies, true)
+}
+
+// hasReclaimCookie is true, if the request contains the cookie for the given hash
+func hasReclaimCookie(c *gin.Context, hash string) bool {
+	token, err := c.Cookie(reclaimCookieName)
+	return err == nil && token != "" && hashReclaimToken(token) == hash
+}
+
+// findReclaimable returns the mac and the entry of the re-claim cookie, if the given mac is randomized and unknown.
+// Doesn't change anything, thus it's safe for a GET.
+func findReclaimable(c *gin.Context, mac structs.Mac) (structs.Mac, db.UserDbEntry, bool) {
+	if !mac.IsLocallyAdministered() {
+		return "", db.UserDbEntry{}, false
+	}
+	if _, ok := macDb.Get(mac); ok {
+		return "", db.UserDbEntry{}, false
+	}
+	token, err := c.Cookie(reclaimCookieName)
+	if err != nil || token == "" {
+		return "", db.UserDbEntry{}, false
+	}
+	oldMac, entry, ok := macDb.FindByReclaimToken(hashReclaimToken(token))
+	if !ok || oldMac == mac {
+		return "", db.UserDbEntry{}, false
+	}
+	return oldMac, entry, true
+}
+
+// reclaimDevice moves the entry of the re-claim cookie to the given mac, see findReclaimable. Returns true, if the
+// entry was moved.
+func reclaimDevice(c *gin.Context, mac structs.Mac) bool {
+	oldMac, entry, ok := findReclaimable(c, mac)
+	if !ok {
+		return false
+	}
+
+	logger.WithFields(logrus.Fields{"mac": mac, "oldMac": oldMac}).Info("Reclaim device with a new randomized mac.")
+	entry.Ts = time.Now().Unix() * 1000
+	// set first, otherwise the person is removed with its last device
+	macDb.Set(mac, entry)
+	macDb.Delete(oldMac)
+	metrics.DeviceReclaims.Inc()
+	return true
+}
+
+type reclaimData struct {
+	SecToken string `form:"secToken" binding:"required"`
+}
+
+// reclaimHandler moves the entry of the re-claim cookie to the requesting device, after the user confirmed it on the
+// overview page
+func reclaimHandler(c *gin.Context) {
+	var form reclaimData
+	if err := c.Bind(&form); err != nil {
+		logger.WithError(err).Error("Invalid binding.")
+		sendError(c, "Invalid binding.")
+		return
+	}
+	info, ok := checkSecToken(c, form.SecToken)
+	if !ok {
+		return
+	}
+
+	if !reclaimDevice(c, info.Mac) {
+		sendError(c, "No entry to re-claim found.")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/?reclaimed=true")
+}
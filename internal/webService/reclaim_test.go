@@ -0,0 +1,70 @@
+package webService
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_reclaimDevice(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "reclaim")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	config := conf.MacDbConf{UserFile: filepath.Join(dir, "userDb.json")}
+	a.NoError(ioutil.WriteFile(config.UserFile, []byte(`{"persons": {}, "devices": {}}`), 0644))
+	macDb = db.NewUserDb(config)
+
+	person := macDb.NewPerson("Hans", db.VisibilityUser)
+	macDb.Set("02:00:00:00:00:01", db.UserDbEntry{PersonId: person.Id, DeviceName: "Handy",
+		ReclaimToken: hashReclaimToken("secret")})
+
+	newContext := func(token string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			c.Request.AddCookie(&http.Cookie{Name: reclaimCookieName, Value: token})
+		}
+		return c
+	}
+
+	// not randomized, no or a wrong cookie
+	a.False(reclaimDevice(newContext("secret"), "00:00:00:00:00:02"))
+	a.False(reclaimDevice(newContext(""), "02:00:00:00:00:02"))
+	a.False(reclaimDevice(newContext("wrong"), "02:00:00:00:00:02"))
+	// the same mac
+	a.False(reclaimDevice(newContext("secret"), "02:00:00:00:00:01"))
+
+	// finding doesn't change anything
+	oldMac, found, ok := findReclaimable(newContext("secret"), "02:00:00:00:00:02")
+	a.True(ok)
+	a.Equal(structs.Mac("02:00:00:00:00:01"), oldMac)
+	a.Equal("Handy", found.DeviceName)
+	_, ok = macDb.Get("02:00:00:00:00:01")
+	a.True(ok)
+	_, ok = macDb.Get("02:00:00:00:00:02")
+	a.False(ok)
+
+	a.True(reclaimDevice(newContext("secret"), "02:00:00:00:00:02"))
+	_, ok = macDb.Get("02:00:00:00:00:01")
+	a.False(ok)
+	entry, ok := macDb.Get("02:00:00:00:00:02")
+	a.True(ok)
+	a.Equal("Handy", entry.DeviceName)
+	_, ok = macDb.GetPerson(person.Id)
+	a.True(ok)
+
+	// known macs are never replaced
+	macDb.Set("02:00:00:00:00:03", db.UserDbEntry{PersonId: person.Id, DeviceName: "Notebook"})
+	a.False(reclaimDevice(newContext("secret"), "02:00:00:00:00:03"))
+}
@@ -4,13 +4,20 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	"github.com/ktt-ol/spaceDevices/internal/conf"
 	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/internal/history"
 	"github.com/ktt-ol/spaceDevices/internal/mqtt"
+	"github.com/ktt-ol/spaceDevices/internal/oui"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,12 +25,22 @@ var logger = logrus.WithField("where", "webSrv")
 
 var devices *mqtt.DeviceData
 var macDb db.UserDb
+var ouiDb *oui.Db
+var presenceHistory *history.PresenceHistory
 var xsrfCheck *SimpleXSRFCheck
+var pairingCodes *PairingCodes
 
-func StartWebService(conf conf.ServerConf, _devices *mqtt.DeviceData, _macDb db.UserDb) {
+// StartWebService starts the http server in the background. Use the returned server to shut it down. The ouiDb and
+// the presenceHistory are optional and can be nil.
+func StartWebService(conf conf.ServerConf, _devices *mqtt.DeviceData, _macDb db.UserDb, _ouiDb *oui.Db,
+	_presenceHistory *history.PresenceHistory) *http.Server {
 	devices = _devices
 	macDb = _macDb
+	ouiDb = _ouiDb
+	presenceHistory = _presenceHistory
 	xsrfCheck = NewSimpleXSRFCheck()
+	pairingCodes = NewPairingCodes()
+	secureCookies = conf.Https
 
 	// use logrus logging
 	gin.DisableConsoleColor()
@@ -37,20 +54,36 @@ func StartWebService(conf conf.ServerConf, _devices *mqtt.DeviceData, _macDb db.
 	router.LoadHTMLGlob("webUI/templates/*.html")
 	router.GET("/", overviewPageHandler)
 	router.POST("/", changeInfoHandler)
+	router.POST("/pairing", pairingCodeHandler)
+	router.POST("/claim", claimHandler)
+	router.POST("/reclaim", reclaimHandler)
+	router.POST("/myDevices", myDevicesHandler)
 	router.GET("/help.html", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "help.html", gin.H{})
 	})
+	router.GET("/api/status", func(c *gin.Context) {
+		c.JSON(http.StatusOK, devices.GetStatus())
+	})
+	addApiRoutes(router)
+	// the gzip middleware already compresses the response
+	router.GET("/metrics", gin.WrapH(promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{DisableCompression: true})))
 
 	addr := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
-	var err error
-	if conf.Https {
-		err = router.RunTLS(addr, conf.CertFile, conf.KeyFile)
-	} else {
-		err = router.Run(addr)
-	}
-	if err != nil {
-		logger.Error("gin exit", err)
-	}
+	server := &http.Server{Addr: addr, Handler: router}
+	go func() {
+		logger.WithField("addr", addr).Info("Listening.")
+		var err error
+		if conf.Https {
+			err = server.ListenAndServeTLS(conf.CertFile, conf.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.WithError(err).Fatal("gin exit")
+		}
+	}()
+
+	return server
 }
 
 func sendError(c *gin.Context, msg string) {
@@ -58,7 +91,40 @@ func sendError(c *gin.Context, msg string) {
 	c.Abort()
 }
 
+// the labels of the db.UserDeviceTypes in the web form
+var deviceTypeLabels = map[string]string{"phone": "Handy", "laptop": "Notebook", "tablet": "Tablet", "desktop": "PC",
+	"watch": "Uhr", "printer": "Drucker", "iot": "IoT/Bastelprojekt", "other": "Sonstiges"}
+
+type deviceTypeOption struct {
+	Value string
+	Label string
+}
+
+// deviceTypeOptions returns the device types in the order of db.UserDeviceTypes
+func deviceTypeOptions() []deviceTypeOption {
+	options := make([]deviceTypeOption, 0, len(db.UserDeviceTypes))
+	for _, deviceType := range db.UserDeviceTypes {
+		label, ok := deviceTypeLabels[deviceType]
+		if !ok {
+			label = deviceType
+		}
+		options = append(options, deviceTypeOption{Value: deviceType, Label: label})
+	}
+	return options
+}
+
+type myDeviceEntry struct {
+	Mac        structs.Mac
+	DeviceName string
+	IsCurrent  bool
+}
+
 func overviewPageHandler(c *gin.Context) {
+	renderOverview(c, "")
+}
+
+// renderOverview shows the index page, the pairingCode is optional
+func renderOverview(c *gin.Context, pairingCode string) {
 	ip, _, _ := net.SplitHostPort(c.Request.RemoteAddr)
 	logger.WithField("ip", ip).Debug("Request ip.")
 
@@ -66,15 +132,45 @@ func overviewPageHandler(c *gin.Context) {
 	mac := "???"
 	deviceName := ""
 	visibility := db.Visibility(99)
+	deviceVisibility := db.VisibilityDefault
+	schedule := ""
+	deviceType := ""
+	vendor := ""
 	isLocallyAdministered := false
 	macNotFound := false
+	hasEntry := false
+	// only the message after the re-claim, see reclaimHandler
+	reclaimed := c.Query("reclaimed") == "true"
+	reclaimable := false
+	reclaimName := ""
+	reclaimDeviceName := ""
+	remembered := false
+	var myDevices []myDeviceEntry
 	if info, ok := devices.GetByIp(ip); ok {
-		mac = info.Mac
-		isLocallyAdministered = db.IsMacLocallyAdministered(mac)
+		mac = info.Mac.String()
+		isLocallyAdministered = info.Mac.IsLocallyAdministered()
+		// the entry is only moved after a confirmation, see reclaimHandler
+		if _, entry, ok := findReclaimable(c, info.Mac); ok {
+			reclaimable = true
+			reclaimDeviceName = entry.DeviceName
+			if person, ok := macDb.GetPerson(entry.PersonId); ok {
+				reclaimName = person.Name
+			}
+		}
+		// only a new device gets the suggested type
+		vendor, deviceType = suggestDeviceType(info.Mac)
 		if userInfo, ok := macDb.Get(info.Mac); ok {
-			name = userInfo.Name
+			hasEntry = true
+			remembered = userInfo.ReclaimToken != "" && hasReclaimCookie(c, userInfo.ReclaimToken)
+			if person, ok := macDb.GetPerson(userInfo.PersonId); ok {
+				name = person.Name
+				visibility = person.DefaultVisibility
+			}
 			deviceName = userInfo.DeviceName
-			visibility = userInfo.Visibility
+			deviceVisibility = userInfo.Visibility
+			schedule = userInfo.Schedule.String()
+			deviceType = userInfo.DeviceType
+			myDevices = findMyDevices(info.Mac, userInfo.PersonId)
 		}
 	} else {
 		macNotFound = true
@@ -86,9 +182,38 @@ func overviewPageHandler(c *gin.Context) {
 		"mac":                   mac,
 		"deviceName":            deviceName,
 		"visibility":            visibility,
+		"deviceVisibility":      deviceVisibility,
+		"schedule":              schedule,
+		"deviceType":            deviceType,
+		"deviceTypes":           deviceTypeOptions(),
+		"vendor":                vendor,
 		"isLocallyAdministered": isLocallyAdministered,
 		"macNotFound":           macNotFound,
+		"hasEntry":              hasEntry,
+		"reclaimed":             reclaimed,
+		"reclaimable":           reclaimable,
+		"reclaimName":           reclaimName,
+		"reclaimDeviceName":     reclaimDeviceName,
+		"remembered":            remembered,
+		"myDevices":             myDevices,
+		"pairingCode":           pairingCode,
+	})
+}
+
+// findMyDevices returns all devices of the given person, sorted by the device name
+func findMyDevices(currentMac structs.Mac, personId string) []myDeviceEntry {
+	entries := macDb.FindByPerson(personId)
+	result := make([]myDeviceEntry, 0, len(entries))
+	for mac, entry := range entries {
+		result = append(result, myDeviceEntry{Mac: mac, DeviceName: entry.DeviceName, IsCurrent: mac == currentMac})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DeviceName != result[j].DeviceName {
+			return result[i].DeviceName < result[j].DeviceName
+		}
+		return result[i].Mac < result[j].Mac
 	})
+	return result
 }
 
 type changeData struct {
@@ -97,6 +222,14 @@ type changeData struct {
 	Name       string        `form:"name" binding:"required"`
 	DeviceName string        `form:"deviceName"`
 	Visibility db.Visibility `form:"visibility" binding:"required"`
+	// optional, overrides the visibility of the person for this device
+	DeviceVisibility db.Visibility `form:"deviceVisibility"`
+	// optional, see db.ParseVisibilitySchedule
+	Schedule string `form:"schedule"`
+	// optional, one of db.UserDeviceTypes
+	DeviceType string `form:"deviceType"`
+	// recognize the device with a re-claim cookie after its randomized mac changed
+	Remember bool `form:"remember"`
 }
 
 func changeInfoHandler(c *gin.Context) {
@@ -130,15 +263,246 @@ func changeInfoHandler(c *gin.Context) {
 	} else if form.Action == "update" {
 		logger.WithField("data", fmt.Sprintf("%#v", form)).Info("Change user info.")
 
-		// visibility, ok := db.ParseVisibility(form.VisibilityNum)
-		// if !ok {
-		// 	logger.WithField("VisibilityNum", form.VisibilityNum).Error("Invalid visibility.")
-		// 	sendError(c, "Invalid 'visibility' value")
-		// 	return
-		// }
+		if !form.Visibility.IsUserVisibility() ||
+			(form.DeviceVisibility != db.VisibilityDefault && !form.DeviceVisibility.IsUserVisibility()) {
+			logger.WithField("visibility", form.Visibility).Error("Invalid visibility.")
+			sendError(c, "Invalid 'visibility' value")
+			return
+		}
+
+		schedule, err := db.ParseVisibilitySchedule(form.Schedule)
+		if err != nil {
+			logger.WithError(err).Error("Invalid schedule.")
+			sendError(c, "Invalid schedule, "+err.Error())
+			return
+		}
+
+		if !db.IsValidUserDeviceType(form.DeviceType) {
+			logger.WithField("deviceType", form.DeviceType).Error("Invalid device type.")
+			sendError(c, "Invalid 'deviceType' value")
+			return
+		}
+
+		entry := saveOwnDevice(info.Mac, form.Name, form.DeviceName, form.DeviceType, form.Visibility, form.DeviceVisibility, schedule)
+		updateReclaimToken(c, info.Mac, entry, form.Remember)
+	}
+
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// saveOwnDevice changes the entry of the given device. The name and the default visibility are set for the person of
+// the device, thus for all its devices. A new person is created if the device has none. The deviceVisibility
+// (VisibilityDefault), the deviceType and the schedule (nil) are optional.
+func saveOwnDevice(mac structs.Mac, name string, deviceName string, deviceType string, defaultVisibility db.Visibility,
+	deviceVisibility db.Visibility, schedule db.VisibilitySchedule) db.UserDbEntry {
+	var person db.Person
+	found := false
+	existing, hasEntry := macDb.Get(mac)
+	if hasEntry {
+		person, found = macDb.GetPerson(existing.PersonId)
+	}
+	if !found {
+		person = macDb.NewPerson(name, defaultVisibility)
+	} else if person.Name != name || person.DefaultVisibility != defaultVisibility {
+		person.Name = name
+		person.DefaultVisibility = defaultVisibility
+		macDb.SetPerson(person)
+	}
 
-		entry := db.UserDbEntry{Name: form.Name, DeviceName: form.DeviceName, Visibility: form.Visibility, Ts: time.Now().Unix() * 1000}
-		macDb.Set(info.Mac, entry)
+	// the last seen and re-claim token are kept
+	entry := db.UserDbEntry{PersonId: person.Id, DeviceName: deviceName, DeviceType: deviceType, Visibility: deviceVisibility,
+		Schedule: schedule, Ts: time.Now().Unix() * 1000, LastSeen: existing.LastSeen, ReclaimToken: existing.ReclaimToken}
+	macDb.Set(mac, entry)
+	return entry
+}
+
+// suggestDeviceType returns the vendor of the mac and the device type suggested by it, both are empty if unknown
+func suggestDeviceType(mac structs.Mac) (string, string) {
+	vendor, ok := ouiDb.Lookup(mac)
+	if !ok {
+		return "", ""
+	}
+	return vendor, db.SuggestDeviceType(vendor)
+}
+
+// updateReclaimToken sets or removes the re-claim cookie and its hash in the entry. Only randomized macs are
+// remembered.
+func updateReclaimToken(c *gin.Context, mac structs.Mac, entry db.UserDbEntry, remember bool) {
+	remember = remember && mac.IsLocallyAdministered()
+	if remember && entry.ReclaimToken != "" && hasReclaimCookie(c, entry.ReclaimToken) {
+		return
+	}
+	if !remember && entry.ReclaimToken == "" {
+		return
+	}
+
+	if remember {
+		entry.ReclaimToken = rememberDevice(c)
+	} else {
+		entry.ReclaimToken = ""
+		forgetDevice(c)
+	}
+	macDb.Set(mac, entry)
+}
+
+// checkSecToken returns the requesting device or sends an error and returns false.
+func checkSecToken(c *gin.Context, secToken string) (structs.WifiSession, bool) {
+	ip, _, _ := net.SplitHostPort(c.Request.RemoteAddr)
+	info, ok := devices.GetByIp(ip)
+	if !ok {
+		logger.WithField("ip", ip).Error("No data for ip found.")
+		sendError(c, "No data for your ip found.")
+		return info, false
+	}
+
+	if !xsrfCheck.CheckAndClearToken(ip, secToken) {
+		logger.WithFields(logrus.Fields{"ip": ip, "secToken": secToken}).Error("Invalid secToken")
+		sendError(c, "Invalid secToken")
+		return info, false
+	}
+
+	return info, true
+}
+
+type pairingData struct {
+	SecToken string `form:"secToken" binding:"required"`
+}
+
+// pairingCodeHandler creates a pairing code, other devices can use it to get the same name
+func pairingCodeHandler(c *gin.Context) {
+	var form pairingData
+	if err := c.Bind(&form); err != nil {
+		logger.WithError(err).Error("Invalid binding.")
+		sendError(c, "Invalid binding.")
+		return
+	}
+	info, ok := checkSecToken(c, form.SecToken)
+	if !ok {
+		return
+	}
+	if _, ok := macDb.Get(info.Mac); !ok {
+		sendError(c, "Your device has no entry.")
+		return
+	}
+
+	logger.WithField("mac", info.Mac).Info("New pairing code.")
+	renderOverview(c, pairingCodes.NewCode(info.Mac))
+}
+
+type claimData struct {
+	SecToken    string `form:"secToken" binding:"required"`
+	PairingCode string `form:"pairingCode" binding:"required"`
+	// optional, an existing entry keeps its device name and type
+	DeviceName string `form:"deviceName"`
+	DeviceType string `form:"deviceType"`
+}
+
+// claimHandler links the requesting device to the person of the device that created the pairing code
+func claimHandler(c *gin.Context) {
+	var form claimData
+	if err := c.Bind(&form); err != nil {
+		logger.WithError(err).Error("Invalid binding.")
+		sendError(c, "Invalid binding.")
+		return
+	}
+	info, ok := checkSecToken(c, form.SecToken)
+	if !ok {
+		return
+	}
+	if !db.IsValidUserDeviceType(form.DeviceType) {
+		sendError(c, "Invalid device type.")
+		return
+	}
+
+	sourceMac, ok := pairingCodes.Redeem(strings.ToUpper(strings.TrimSpace(form.PairingCode)))
+	if !ok {
+		logger.WithField("mac", info.Mac).Warn("Invalid pairing code.")
+		sendError(c, "Invalid or expired pairing code.")
+		return
+	}
+	source, ok := macDb.Get(sourceMac)
+	if !ok {
+		sendError(c, "The entry of the other device is gone.")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{"mac": info.Mac, "sourceMac": sourceMac}).Info("Claim device.")
+	// a former person without other devices is removed
+	macDb.Set(info.Mac, claimedEntry(info.Mac, source.PersonId, form.DeviceName, form.DeviceType))
+
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// claimedEntry returns the entry of the claiming device, linked to the given person. An existing entry keeps all its
+// data, the device name and type are only changed if given. A new device uses the default visibility of the person and
+// the device type suggested by its vendor.
+func claimedEntry(mac structs.Mac, personId string, deviceName string, deviceType string) db.UserDbEntry {
+	entry, ok := macDb.Get(mac)
+	if !ok {
+		entry = db.UserDbEntry{Visibility: db.VisibilityDefault}
+		_, entry.DeviceType = suggestDeviceType(mac)
+	}
+	entry.PersonId = personId
+	if deviceName != "" {
+		entry.DeviceName = deviceName
+	}
+	if deviceType != "" {
+		entry.DeviceType = deviceType
+	}
+	entry.Ts = time.Now().Unix() * 1000
+	return entry
+}
+
+type myDevicesData struct {
+	Action     string `form:"action" binding:"required"`
+	SecToken   string `form:"secToken" binding:"required"`
+	Mac        string `form:"mac" binding:"required"`
+	DeviceName string `form:"deviceName"`
+}
+
+// myDevicesHandler renames or deletes one of the devices of the person of the requesting device
+func myDevicesHandler(c *gin.Context) {
+	var form myDevicesData
+	if err := c.Bind(&form); err != nil {
+		logger.WithError(err).Error("Invalid binding.")
+		sendError(c, "Invalid binding.")
+		return
+	}
+	info, ok := checkSecToken(c, form.SecToken)
+	if !ok {
+		return
+	}
+
+	targetMac, err := structs.ParseMac(form.Mac)
+	if err != nil {
+		logger.WithError(err).Warn("Invalid target mac.")
+		sendError(c, "Invalid mac.")
+		return
+	}
+	own, ok := macDb.Get(info.Mac)
+	if !ok {
+		sendError(c, "Your device has no entry.")
+		return
+	}
+	target, ok := macDb.Get(targetMac)
+	if !ok || target.PersonId != own.PersonId {
+		logger.WithFields(logrus.Fields{"mac": info.Mac, "target": targetMac}).Warn("Not an own device.")
+		sendError(c, "Not one of your devices.")
+		return
+	}
+
+	switch form.Action {
+	case "rename":
+		logger.WithFields(logrus.Fields{"mac": info.Mac, "target": targetMac}).Info("Rename own device.")
+		target.DeviceName = form.DeviceName
+		target.Ts = time.Now().Unix() * 1000
+		macDb.Set(targetMac, target)
+	case "delete":
+		logger.WithFields(logrus.Fields{"mac": info.Mac, "target": targetMac}).Info("Delete own device.")
+		macDb.Delete(targetMac)
+	default:
+		sendError(c, "Invalid action.")
+		return
 	}
 
 	c.Redirect(http.StatusSeeOther, "/")
@@ -0,0 +1,86 @@
+package webService
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ktt-ol/spaceDevices/internal/conf"
+	"github.com/ktt-ol/spaceDevices/internal/db"
+	"github.com/ktt-ol/spaceDevices/internal/oui"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_suggestDeviceType(t *testing.T) {
+	a := assert.New(t)
+	ouiDb = oui.New()
+	a.NoError(ouiDb.Read(strings.NewReader("5C514F,Intel Corporate\n000000,Unknown Vendor\n")))
+	defer func() { ouiDb = nil }()
+
+	vendor, deviceType := suggestDeviceType("5c:51:4f:00:00:01")
+	a.Equal("Intel Corporate", vendor)
+	a.Equal("laptop", deviceType)
+
+	vendor, deviceType = suggestDeviceType("00:00:00:00:00:01")
+	a.Equal("Unknown Vendor", vendor)
+	a.Equal("", deviceType)
+
+	vendor, deviceType = suggestDeviceType("02:00:00:00:00:01")
+	a.Equal("", vendor)
+	a.Equal("", deviceType)
+}
+
+func Test_deviceTypeOptions(t *testing.T) {
+	a := assert.New(t)
+	options := deviceTypeOptions()
+	a.Len(options, len(db.UserDeviceTypes))
+	for i, option := range options {
+		a.Equal(db.UserDeviceTypes[i], option.Value)
+		a.NotEqual(option.Value, option.Label, "missing label")
+	}
+}
+
+func Test_claimedEntry(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "claim")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	config := conf.MacDbConf{UserFile: filepath.Join(dir, "userDb.json")}
+	a.NoError(ioutil.WriteFile(config.UserFile, []byte(`{"persons": {}, "devices": {}}`), 0644))
+	macDb = db.NewUserDb(config)
+	ouiDb = oui.New()
+	a.NoError(ouiDb.Read(strings.NewReader("5C514F,Intel Corporate\n")))
+	defer func() { ouiDb = nil }()
+
+	hans := macDb.NewPerson("Hans", db.VisibilityUser)
+	olaf := macDb.NewPerson("Olaf", db.VisibilityAll)
+	schedule := db.VisibilitySchedule{{Days: []string{"mon"}, From: "08:00", To: "18:00", Visibility: db.VisibilityIgnore}}
+	existing := db.UserDbEntry{PersonId: olaf.Id, DeviceName: "Handy", DeviceType: "phone", Visibility: db.VisibilityAnon,
+		Schedule: schedule, LastSeen: 1000, ReclaimToken: "token"}
+	macDb.Set("5c:51:4f:00:00:01", existing)
+
+	// an existing entry only changes the person
+	entry := claimedEntry("5c:51:4f:00:00:01", hans.Id, "", "")
+	a.Equal(hans.Id, entry.PersonId)
+	a.Equal("Handy", entry.DeviceName)
+	a.Equal("phone", entry.DeviceType)
+	a.Equal(db.VisibilityAnon, entry.Visibility)
+	a.Equal(schedule, entry.Schedule)
+	a.Equal(int64(1000), entry.LastSeen)
+	a.Equal("token", entry.ReclaimToken)
+
+	entry = claimedEntry("5c:51:4f:00:00:01", hans.Id, "Tablet", "tablet")
+	a.Equal("Tablet", entry.DeviceName)
+	a.Equal("tablet", entry.DeviceType)
+	a.Equal(db.VisibilityAnon, entry.Visibility)
+
+	// a new device
+	entry = claimedEntry("5c:51:4f:00:00:02", hans.Id, "", "")
+	a.Equal(hans.Id, entry.PersonId)
+	a.Equal("", entry.DeviceName)
+	a.Equal("laptop", entry.DeviceType)
+	a.Equal(db.VisibilityDefault, entry.Visibility)
+}
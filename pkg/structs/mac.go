@@ -0,0 +1,78 @@
+package structs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Mac is a 48 bit mac address in the canonical format, lower case and colon separated, e.g. "20:c9:d0:7a:fa:31". It's
+// used as the key of the databases, use ParseMac to get one from any other input.
+type Mac string
+
+// ParseMac accepts the formats "20:c9:d0:7a:fa:31", "20-C9-D0-7A-FA-31", "20c9.d07a.fa31" and "20c9d07afa31" (ignoring
+// the case) and returns the canonical mac.
+func ParseMac(value string) (Mac, error) {
+	value = strings.TrimSpace(value)
+	var digits string
+	switch len(value) {
+	case 12:
+		digits = value
+	case 14:
+		// cisco style
+		if value[4] != '.' || value[9] != '.' {
+			return "", fmt.Errorf("invalid mac '%s'", value)
+		}
+		digits = value[0:4] + value[5:9] + value[10:14]
+	case 17:
+		separator := value[2]
+		if separator != ':' && separator != '-' {
+			return "", fmt.Errorf("invalid mac '%s'", value)
+		}
+		for i := 0; i < 6; i++ {
+			if i > 0 && value[i*3-1] != separator {
+				return "", fmt.Errorf("invalid mac '%s'", value)
+			}
+			digits += value[i*3 : i*3+2]
+		}
+	default:
+		return "", fmt.Errorf("invalid mac '%s'", value)
+	}
+
+	bytes, err := hex.DecodeString(digits)
+	if err != nil {
+		return "", fmt.Errorf("invalid mac '%s'", value)
+	}
+	return Mac(net.HardwareAddr(bytes).String()), nil
+}
+
+func (m Mac) String() string {
+	return string(m)
+}
+
+// IsLocallyAdministered returns true, if the second bit of the first byte is set, e.g. for randomized macs.
+// https://en.wikipedia.org/wiki/MAC_address
+func (m Mac) IsLocallyAdministered() bool {
+	// 00000010
+	const mask = 1 << 1
+
+	if len(m) < 2 {
+		return false
+	}
+	first, err := hex.DecodeString(string(m[:2]))
+	if err != nil {
+		return false
+	}
+	return first[0]&mask == mask
+}
+
+// UnmarshalText parses any supported format, thus the macs of JSON values and map keys are canonical.
+func (m *Mac) UnmarshalText(text []byte) error {
+	mac, err := ParseMac(string(text))
+	if err != nil {
+		return err
+	}
+	*m = mac
+	return nil
+}
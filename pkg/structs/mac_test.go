@@ -0,0 +1,50 @@
+package structs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseMac(t *testing.T) {
+	a := assert.New(t)
+
+	valid := []string{"20:c9:d0:7a:fa:31", "20:C9:D0:7A:FA:31", "20-c9-d0-7a-fa-31", "20c9.d07a.fa31", "20C9D07AFA31",
+		" 20:c9:d0:7a:fa:31 "}
+	for _, value := range valid {
+		mac, err := ParseMac(value)
+		a.NoError(err, value)
+		a.Equal(Mac("20:c9:d0:7a:fa:31"), mac, value)
+	}
+
+	invalid := []string{"", "2", "20:c9:d0:7a:fa", "20:c9:d0:7a:fa:3g", "20:c9-d0:7a:fa:31", "20:c9:d0:7a:fa:31:00",
+		"20c9:d07a:fa31", "20c9d07afa3", "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00"}
+	for _, value := range invalid {
+		_, err := ParseMac(value)
+		a.Error(err, value)
+	}
+}
+
+func Test_Mac_IsLocallyAdministered(t *testing.T) {
+	a := assert.New(t)
+	a.True(Mac("06:00:00:00:00:00").IsLocallyAdministered())
+	a.False(Mac("20:c9:d0:7a:fa:31").IsLocallyAdministered())
+	// no panic for invalid macs
+	a.False(Mac("").IsLocallyAdministered())
+	a.False(Mac("2").IsLocallyAdministered())
+}
+
+func Test_Mac_json(t *testing.T) {
+	a := assert.New(t)
+
+	var parsed map[Mac]Devices
+	a.NoError(json.Unmarshal([]byte(`{"AA-BB-CC-DD-EE-FF": {"name": "printer"}}`), &parsed))
+	a.Equal("printer", parsed["aa:bb:cc:dd:ee:ff"].Name)
+
+	var entry InfrastructureEvent
+	a.NoError(json.Unmarshal([]byte(`{"mac": "AABB.CCDD.EEFF"}`), &entry))
+	a.Equal(Mac("aa:bb:cc:dd:ee:ff"), entry.Mac)
+
+	a.Error(json.Unmarshal([]byte(`{"no mac": {"name": "printer"}}`), &parsed))
+}
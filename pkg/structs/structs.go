@@ -1,13 +1,12 @@
 package structs
 
-import (
-	"strings"
-)
+import "strings"
 
 type WifiSession struct {
-	Ipv4     string
-	Ipv6     []string
-	Mac      string
+	Ipv4 string
+	Ipv6 []string
+	// always canonical, invalid macs are rejected while parsing
+	Mac      Mac
 	AP       int
 	Location string
 }
@@ -15,6 +14,8 @@ type WifiSession struct {
 type Devices struct {
 	Name     string `json:"name"`
 	Location string `json:"location"`
+	// the device-type of the master or user db, if any
+	Type string `json:"type,omitempty"`
 }
 
 type DevicesSorter []Devices
@@ -51,4 +52,104 @@ type PeopleAndDevices struct {
 	PeopleCount         uint16   `json:"peopleCount"`
 	DeviceCount         uint16   `json:"deviceCount"`
 	UnknownDevicesCount uint16   `json:"unknownDevicesCount"`
+	// the unknown devices with a randomized (locally administered) mac
+	UnknownRandomizedDevicesCount uint16 `json:"unknownRandomizedDevicesCount"`
+	// the present devices per device-type of the master and user db, regardless of their visibility. Devices without a
+	// type are not counted.
+	DeviceTypes map[string]uint16 `json:"deviceTypes,omitempty"`
+	// true, if we didn't get any sessions for a while and the data is outdated
+	Stale bool `json:"stale,omitempty"`
+}
+
+type PresenceEventType string
+
+const (
+	PersonArrived PresenceEventType = "person-arrived"
+	PersonLeft    PresenceEventType = "person-left"
+	DeviceArrived PresenceEventType = "device-arrived"
+	DeviceLeft    PresenceEventType = "device-left"
+)
+
+// DeviceTypeSummary is sent (retained) to the device types topic on every change
+type DeviceTypeSummary struct {
+	Types map[string]uint16 `json:"types"`
+	// unix time in ms
+	Ts int64 `json:"ts"`
+}
+
+// PresenceEvent is a single change between two PeopleAndDevices. The device fields are only set for the device events.
+type PresenceEvent struct {
+	Type     PresenceEventType `json:"type"`
+	Person   string            `json:"person"`
+	Device   string            `json:"device,omitempty"`
+	Location string            `json:"location,omitempty"`
+	// unix time in ms
+	Ts int64 `json:"ts"`
+}
+
+// InfrastructureDevice is the state of one critical or important infrastructure device of the master db
+type InfrastructureDevice struct {
+	Mac  Mac    `json:"mac"`
+	Name string `json:"name"`
+	// the visibility of the master db entry, "critical-infrastructure" or "important-infrastructure"
+	Visibility string `json:"visibility"`
+	Online     bool   `json:"online"`
+	// unix time in ms of the last online/offline change, the start of the service if it was never online
+	Since int64 `json:"since"`
+	// true, if a critical device is missing for longer than the alert time
+	Alert bool `json:"alert"`
+}
+
+// InfrastructureStatus is sent (retained) to the infrastructure topic on every change
+type InfrastructureStatus struct {
+	// unix time in ms
+	Ts      int64                  `json:"ts"`
+	Devices []InfrastructureDevice `json:"devices"`
+}
+
+type InfrastructureEventType string
+
+const (
+	InfrastructureMissing InfrastructureEventType = "infrastructure-missing"
+	InfrastructureBack    InfrastructureEventType = "infrastructure-back"
+)
+
+// InfrastructureEvent is an alert for a critical infrastructure device, sent to the events topic
+type InfrastructureEvent struct {
+	Type InfrastructureEventType `json:"type"`
+	Mac  Mac                     `json:"mac"`
+	Name string                  `json:"name"`
+	// unix time in ms since the device is missing
+	MissingSince int64 `json:"missingSince"`
+	// unix time in ms
+	Ts int64 `json:"ts"`
+}
+
+// PoweredWarning is sent while the space is closed and devices with the powered-while-closed-warning are online
+type PoweredWarning struct {
+	Devices []Devices `json:"devices"`
+	// unix time in ms since the space is closed
+	ClosedSince int64 `json:"closedSince"`
+	// unix time in ms
+	Ts int64 `json:"ts"`
+}
+
+const (
+	StateOnline  = "online"
+	StateOffline = "offline"
+)
+
+// ServiceStatus is sent (retained) to the status topic. All timestamps are unix time in ms, 0 means never.
+type ServiceStatus struct {
+	State          string `json:"state"`
+	Ts             int64  `json:"ts"`
+	MqttConnected  bool   `json:"mqttConnected"`
+	MqttReconnects uint64 `json:"mqttReconnects"`
+	// the last payload from the session topic
+	LastSessionsTs int64 `json:"lastSessionsTs"`
+	// the last successful publish of the devices
+	LastPublishTs   int64  `json:"lastPublishTs"`
+	ParseErrors     uint64 `json:"parseErrors"`
+	MasterDbEntries int    `json:"masterDbEntries"`
+	UserDbEntries   int    `json:"userDbEntries"`
 }